@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// runCommand executes an administrative subcommand instead of a backup run.
+// Usage:
+//
+//	cluster-backup pin <run-id|label>
+//	cluster-backup unpin <run-id|label>
+//	cluster-backup snapshots
+//	cluster-backup verify [run-id|label]
+//	cluster-backup drift [--namespace ns] [--format text|json] [--watch interval]
+//	cluster-backup diff [--namespace ns,...] [--kind kind,...] [--format text|json] <from> <to>
+//	cluster-backup versions <key>
+//	cluster-backup get-version <key> <version-id>
+//	cluster-backup restore-as-of [--namespace ns] [--output dir] [--apply] [--include-owned] <timestamp>
+//
+// Reports are written to stdout and logs to stderr, so a report can be piped
+// or redirected on its own.
+func runCommand(cb *ClusterBackup, args []string) error {
+	switch args[0] {
+	case "pin":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: pin <run-id|label>")
+		}
+		pin, err := cb.pinRun(args[1])
+		if err != nil {
+			return err
+		}
+		cb.logger.Info("snapshot_pinned", "Run pinned, it will be skipped by retention cleanup", map[string]interface{}{
+			"run_id": pin.RunID,
+			"label":  pin.Label,
+		})
+		return nil
+
+	case "unpin":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: unpin <run-id|label>")
+		}
+		runID, err := cb.unpinRun(args[1])
+		if err != nil {
+			return err
+		}
+		cb.logger.Info("snapshot_unpinned", "Run unpinned, normal retention applies again", map[string]interface{}{
+			"run_id": runID,
+		})
+		return nil
+
+	case "snapshots":
+		return cb.printSnapshots()
+
+	case "verify":
+		if len(args) > 2 {
+			return fmt.Errorf("usage: verify [run-id|label]")
+		}
+		ref := ""
+		if len(args) == 2 {
+			ref = args[1]
+		}
+		return cb.runVerify(ref)
+
+	case "drift":
+		flags := flag.NewFlagSet("drift", flag.ContinueOnError)
+		namespace := flags.String("namespace", "", "compare only this namespace")
+		format := flags.String("format", "text", "report format: text or json")
+		watch := flags.Duration("watch", 0, "recompute the drift at this interval and serve metrics")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *format != "text" && *format != "json" {
+			return fmt.Errorf("invalid format %q (expected text or json)", *format)
+		}
+		return cb.runDrift(*namespace, *format, *watch)
+
+	case "diff":
+		flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+		namespaces := flags.String("namespace", "", "comma-separated namespace patterns to compare")
+		kinds := flags.String("kind", "", "comma-separated kinds or resource types to compare")
+		format := flags.String("format", "text", "report format: text or json")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 2 {
+			return fmt.Errorf("usage: diff [--namespace ns,...] [--kind kind,...] [--format text|json] <[cluster/]ref> <[cluster/]ref>")
+		}
+		if *format != "text" && *format != "json" {
+			return fmt.Errorf("invalid format %q (expected text or json)", *format)
+		}
+		return cb.runDiff(flags.Arg(0), flags.Arg(1), parseCommaSeparated(*namespaces), parseCommaSeparated(*kinds), *format)
+
+	case "versions":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: versions <key>")
+		}
+		return cb.printObjectVersions(args[1])
+
+	case "get-version":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: get-version <key> <version-id>")
+		}
+		return cb.printObjectVersion(args[1], args[2])
+
+	case "restore-as-of":
+		flags := flag.NewFlagSet("restore-as-of", flag.ContinueOnError)
+		namespace := flags.String("namespace", "", "restore only this namespace")
+		outputDir := flags.String("output", "", "directory to write the restored objects to (default restore-<timestamp>)")
+		apply := flags.Bool("apply", false, "server-side apply the restored objects to the cluster")
+		includeOwned := flags.Bool("include-owned", false, "also apply objects whose controller owner is restored")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return fmt.Errorf("usage: restore-as-of [--namespace ns] [--output dir] [--apply] [--include-owned] <timestamp>")
+		}
+		asOf, err := parseTimestamp(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		if *outputDir == "" && !*apply {
+			*outputDir = "restore-" + asOf.UTC().Format("20060102T150405Z")
+		}
+		return cb.restoreAsOf(asOf, *namespace, *outputDir, *apply, *includeOwned)
+
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// printSnapshots writes the recorded runs of this cluster to stdout, with
+// pinned runs listed separately from the ones subject to retention.
+func (cb *ClusterBackup) printSnapshots() error {
+	records, err := cb.listRunRecords()
+	if err != nil {
+		return fmt.Errorf("failed to list runs: %v", err)
+	}
+	pins, err := cb.pinnedRuns()
+	if err != nil {
+		return fmt.Errorf("failed to list pins: %v", err)
+	}
+
+	type pinnedRun struct {
+		RunRecord
+		Pin PinRecord `json:"pin"`
+	}
+	report := struct {
+		Cluster string      `json:"cluster"`
+		Pinned  []pinnedRun `json:"pinned"`
+		Runs    []RunRecord `json:"runs"`
+	}{Cluster: cb.config.ClusterName, Pinned: []pinnedRun{}, Runs: []RunRecord{}}
+
+	for _, record := range records {
+		if pin, ok := pins[record.RunID]; ok {
+			report.Pinned = append(report.Pinned, pinnedRun{RunRecord: record, Pin: pin})
+			continue
+		}
+		report.Runs = append(report.Runs, record)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// runDrift prints a drift report once, or with a watch interval keeps
+// recomputing it and exposes the drift gauges on the metrics endpoint.
+func (cb *ClusterBackup) runDrift(namespace, format string, watch time.Duration) error {
+	if watch <= 0 {
+		report, err := cb.computeDrift(namespace)
+		if err != nil {
+			return err
+		}
+		cb.updateDriftMetrics(report)
+		return writeDriftReport(os.Stdout, report, format)
+	}
+
+	go startMetricsServer()
+	for {
+		report, err := cb.computeDrift(namespace)
+		if err != nil {
+			cb.logger.Error("drift_failed", "Drift computation failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			cb.updateDriftMetrics(report)
+			if err := writeDriftReport(os.Stdout, report, format); err != nil {
+				return err
+			}
+			cb.logger.Info("drift_complete", "Drift computed", map[string]interface{}{
+				"baseline": report.Baseline,
+				"added":    len(report.Added),
+				"modified": len(report.Modified),
+				"deleted":  len(report.Deleted),
+			})
+		}
+		time.Sleep(watch)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var crdGVR = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// clusterScopedDir is the namespace segment CRDs are stored under,
+// {prefix}_cluster/customresourcedefinitions/{name}.yaml. Namespace names
+// cannot start with an underscore.
+const clusterScopedDir = "_cluster"
+
+// crdEstablishTimeout bounds the wait for restored CRDs to be served.
+const crdEstablishTimeout = 60 * time.Second
+
+// CRDRecord describes a CRD stored with its custom resources. Custom
+// resources are stored in BackupVersion and must be restored after the CRD
+// is established; StorageVersion is the version the API server persists.
+type CRDRecord struct {
+	Name           string   `json:"name"`
+	Group          string   `json:"group"`
+	Kind           string   `json:"kind"`
+	Plural         string   `json:"plural"`
+	Scope          string   `json:"scope"`
+	StorageVersion string   `json:"storage_version"`
+	ServedVersions []string `json:"served_versions"`
+	BackupVersion  string   `json:"backup_version"`
+	Key            string   `json:"key"`
+}
+
+// crdRecordOf reads the identity and versions of a CRD.
+func crdRecordOf(crd *unstructured.Unstructured) CRDRecord {
+	record := CRDRecord{
+		Name:   crd.GetName(),
+		Group:  nestedString(crd.Object, "spec", "group"),
+		Kind:   nestedString(crd.Object, "spec", "names", "kind"),
+		Plural: nestedString(crd.Object, "spec", "names", "plural"),
+		Scope:  nestedString(crd.Object, "spec", "scope"),
+	}
+	for _, version := range nestedMaps(crd.Object, "spec", "versions") {
+		name := nestedString(version, "name")
+		if served, _, _ := unstructured.NestedBool(version, "served"); served {
+			record.ServedVersions = append(record.ServedVersions, name)
+		}
+		if storage, _, _ := unstructured.NestedBool(version, "storage"); storage {
+			record.StorageVersion = name
+		}
+	}
+	return record
+}
+
+// cleanCRD prepares a CRD for storage. Status is always dropped, it is
+// rebuilt by the API server. Conversion webhook CA bundles are usually
+// injected by cert-manager or an operator and are stale after a restore.
+func (cb *ClusterBackup) cleanCRD(crd *unstructured.Unstructured) map[string]interface{} {
+	cleaned := cb.cleanResource(crd)
+	delete(cleaned, "status")
+	if cb.backupConfig.StripCRDCABundle {
+		unstructured.RemoveNestedField(cleaned, "spec", "conversion", "webhook", "clientConfig", "caBundle")
+	}
+	return cleaned
+}
+
+// customResourceDefinitions returns the CRDs of the cluster keyed by
+// plural.group. The list is read once per run.
+func (cb *ClusterBackup) customResourceDefinitions() (map[string]*unstructured.Unstructured, error) {
+	if cb.crdIndex != nil {
+		return cb.crdIndex, nil
+	}
+	crds, err := cb.dynamicClient.Resource(crdGVR).List(cb.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list CRDs: %v", err)
+	}
+	index := make(map[string]*unstructured.Unstructured, len(crds.Items))
+	for i := range crds.Items {
+		crd := &crds.Items[i]
+		index[nestedString(crd.Object, "spec", "names", "plural")+"."+nestedString(crd.Object, "spec", "group")] = crd
+	}
+	cb.crdIndex = index
+	return index, nil
+}
+
+// matchesResourcePattern matches a resource against a <resource>.<group>
+// pattern. Both parts are globs matched separately, so the group has to
+// match as a whole: "*.argoproj.io" selects every resource of argoproj.io
+// but not of x.argoproj.io, which "*.*.argoproj.io" selects.
+func matchesResourcePattern(resource, group, pattern string) bool {
+	i := strings.Index(pattern, ".")
+	if i < 0 || group == "" {
+		return false
+	}
+	resourceMatched, _ := path.Match(strings.ToLower(pattern[:i]), resource)
+	groupMatched, _ := path.Match(strings.ToLower(pattern[i+1:]), group)
+	return resourceMatched && groupMatched
+}
+
+func matchesAnyResourcePattern(resource, group string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matchesResourcePattern(resource, group, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectCustomResource decides on resources outside the core group beyond
+// the resource filters: include-crds patterns and include-all-crds add them,
+// exclude-crds patterns remove them. custom tells whether a CRD serves the
+// resource; exclude-crds only applies then, so a pattern such as *.apps
+// cannot drop built-in resources.
+func (cb *ClusterBackup) selectCustomResource(resource metav1.APIResource, custom, included bool) bool {
+	if custom && matchesAnyResourcePattern(resource.Name, resource.Group, cb.backupConfig.ExcludeCRDs) {
+		return false
+	}
+	if included || (custom && cb.backupConfig.IncludeAllCRDs) {
+		return true
+	}
+	return matchesAnyResourcePattern(resource.Name, resource.Group, cb.backupConfig.IncludeCRDs)
+}
+
+// backupCRDs stores the CRD of every custom resource type in the backup set
+// and records it in the run manifest. It returns the number of CRDs stored.
+func (cb *ClusterBackup) backupCRDs(apiResources []metav1.APIResource) (int, error) {
+	byResource, err := cb.customResourceDefinitions()
+	if err != nil {
+		return 0, err
+	}
+
+	stored := make(map[string]bool)
+	var errors []string
+	for _, resource := range apiResources {
+		crd, ok := byResource[resource.Name+"."+resource.Group]
+		if !ok || stored[crd.GetName()] {
+			continue
+		}
+		stored[crd.GetName()] = true
+
+		if err := cb.uploadResource(clusterScopedDir, crdGVR, crd, cb.cleanCRD(crd)); err != nil {
+			errors = append(errors, fmt.Sprintf("%s: %v", crd.GetName(), err))
+			continue
+		}
+		record := crdRecordOf(crd)
+		record.BackupVersion = resource.Version
+		record.Key = cb.objectKey(clusterScopedDir, crdGVR.Resource, crd.GetName())
+		cb.recordCRD(record)
+
+		cb.logger.Debug("crd_uploaded", "CRD stored with its custom resources", map[string]interface{}{
+			"crd":             record.Name,
+			"storage_version": record.StorageVersion,
+			"backup_version":  record.BackupVersion,
+		})
+	}
+
+	if len(errors) > 0 {
+		return len(stored) - len(errors), fmt.Errorf("%d CRDs failed, first: %s", len(errors), errors[0])
+	}
+	return len(stored), nil
+}
+
+func (cb *ClusterBackup) recordCRD(record CRDRecord) {
+	cb.manifestMu.Lock()
+	defer cb.manifestMu.Unlock()
+	cb.crdRecords = append(cb.crdRecords, record)
+}
+
+// isCRDKey reports whether a key relative to a backup prefix holds a CRD.
+func isCRDKey(rel string) bool {
+	_, resourceType, _, ok := splitRelativeKey(rel)
+	return ok && resourceType == crdGVR.Resource
+}
+
+// restoreOrder orders restore keys by restorePriority, so CRDs are applied
+// before the custom resources that need them and namespaces before their
+// content.
+func restoreOrder(keys []string, prefix string) []string {
+	ordered := append([]string(nil), keys...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return restorePriority(strings.TrimPrefix(ordered[i], prefix)) < restorePriority(strings.TrimPrefix(ordered[j], prefix))
+	})
+	return ordered
+}
+
+// waitForCRDs waits until the named CRDs report the Established condition,
+// so custom resources can be applied.
+func (cb *ClusterBackup) waitForCRDs(names []string) error {
+	deadline := time.Now().Add(crdEstablishTimeout)
+	for _, name := range names {
+		for {
+			crd, err := cb.dynamicClient.Resource(crdGVR).Get(cb.ctx, name, metav1.GetOptions{})
+			if err == nil && crdEstablished(crd) {
+				break
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("CRD %s not established after %s", name, crdEstablishTimeout)
+			}
+			time.Sleep(time.Second)
+		}
+	}
+	return nil
+}
+
+func crdEstablished(crd *unstructured.Unstructured) bool {
+	for _, condition := range nestedMaps(crd.Object, "status", "conditions") {
+		if nestedString(condition, "type") == "Established" && nestedString(condition, "status") == "True" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMatchesResourcePattern(t *testing.T) {
+	tests := []struct {
+		resource string
+		group    string
+		pattern  string
+		want     bool
+	}{
+		{"applications", "argoproj.io", "applications.argoproj.io", true},
+		{"applications", "argoproj.io", "*.argoproj.io", true},
+		{"rollouts", "argoproj.io", "*.argoproj.io", true},
+		{"applications", "x.argoproj.io", "*.argoproj.io", false},
+		{"applications", "x.argoproj.io", "*.*.argoproj.io", true},
+		{"applications", "argoproj.io.evil.com", "*.argoproj.io", false},
+		{"applications", "notargoproj.io", "*.argoproj.io", false},
+		{"applicationsets", "argoproj.io", "applications.argoproj.io", false},
+		{"applications", "argoproj.io", "Applications.ArgoProj.io", true},
+		{"certificates", "cert-manager.io", "*", false},
+		{"pods", "", "pods.", false},
+		{"pods", "", "*.*", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+"/"+tt.resource+"."+tt.group, func(t *testing.T) {
+			if got := matchesResourcePattern(tt.resource, tt.group, tt.pattern); got != tt.want {
+				t.Errorf("matchesResourcePattern(%q, %q, %q) = %v, want %v", tt.resource, tt.group, tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoreOrder(t *testing.T) {
+	const prefix = "clusterbackup/c1/snapshots/20240301-120000/"
+	tests := []struct {
+		name string
+		keys []string
+		want []string
+	}{
+		{
+			name: "empty",
+			keys: nil,
+			want: []string{},
+		},
+		{
+			name: "CRDs, namespaces, quotas, then content",
+			keys: []string{
+				prefix + "app/deployments/web.yaml",
+				prefix + "app/limitranges/limits.yaml",
+				prefix + "app/widgets/w1.yaml",
+				prefix + "app/namespaces/app.yaml",
+				prefix + "_cluster/customresourcedefinitions/widgets.example.com.yaml",
+				prefix + "app/resourcequotas/quota.yaml",
+				prefix + "app/projects/app.yaml",
+			},
+			want: []string{
+				prefix + "_cluster/customresourcedefinitions/widgets.example.com.yaml",
+				prefix + "app/namespaces/app.yaml",
+				prefix + "app/limitranges/limits.yaml",
+				prefix + "app/resourcequotas/quota.yaml",
+				prefix + "app/deployments/web.yaml",
+				prefix + "app/widgets/w1.yaml",
+				prefix + "app/projects/app.yaml",
+			},
+		},
+		{
+			name: "stable within a priority",
+			keys: []string{
+				prefix + "b/namespaces/b.yaml",
+				prefix + "b/configmaps/cm.yaml",
+				prefix + "a/namespaces/a.yaml",
+				prefix + "a/configmaps/cm.yaml",
+			},
+			want: []string{
+				prefix + "b/namespaces/b.yaml",
+				prefix + "a/namespaces/a.yaml",
+				prefix + "b/configmaps/cm.yaml",
+				prefix + "a/configmaps/cm.yaml",
+			},
+		},
+		{
+			name: "keys that are not objects go last",
+			keys: []string{
+				prefix + "manifest.json",
+				prefix + "app/namespaces/app.yaml",
+			},
+			want: []string{
+				prefix + "app/namespaces/app.yaml",
+				prefix + "manifest.json",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := append([]string(nil), tt.keys...)
+			got := restoreOrder(tt.keys, prefix)
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("restoreOrder =\n%q\nwant\n%q", got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.keys, keys) {
+				t.Errorf("restoreOrder modified its input")
+			}
+		})
+	}
+}
+
+func TestSelectCustomResource(t *testing.T) {
+	deployments := metav1.APIResource{Name: "deployments", Group: "apps"}
+	applications := metav1.APIResource{Name: "applications", Group: "argoproj.io"}
+	widgets := metav1.APIResource{Name: "widgets", Group: "example.com"}
+
+	tests := []struct {
+		name     string
+		config   BackupConfig
+		resource metav1.APIResource
+		custom   bool
+		included bool
+		want     bool
+	}{
+		{"built-in kept by filters", BackupConfig{}, deployments, false, true, true},
+		{"built-in not excluded by exclude-crds", BackupConfig{ExcludeCRDs: []string{"*.apps"}}, deployments, false, true, true},
+		{"built-in not added by include-all-crds", BackupConfig{IncludeAllCRDs: true}, deployments, false, false, false},
+		{"custom excluded though included", BackupConfig{ExcludeCRDs: []string{"*.argoproj.io"}}, applications, true, true, false},
+		{"exclude beats include-all-crds", BackupConfig{IncludeAllCRDs: true, ExcludeCRDs: []string{"applications.argoproj.io"}}, applications, true, false, false},
+		{"include-all-crds", BackupConfig{IncludeAllCRDs: true}, widgets, true, false, true},
+		{"include-crds pattern", BackupConfig{IncludeCRDs: []string{"*.example.com"}}, widgets, true, false, true},
+		{"include-crds pattern on another group", BackupConfig{IncludeCRDs: []string{"*.argoproj.io"}}, widgets, true, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			cb := &ClusterBackup{backupConfig: &config}
+			if got := cb.selectCustomResource(tt.resource, tt.custom, tt.included); got != tt.want {
+				t.Errorf("selectCustomResource = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Resource types dependencies can point at.
+var (
+	configMapsGVR      = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	secretsGVR         = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	pvcsGVR            = schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"}
+	pvsGVR             = schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumes"}
+	serviceAccountsGVR = schema.GroupVersionResource{Version: "v1", Resource: "serviceaccounts"}
+	servicesGVR        = schema.GroupVersionResource{Version: "v1", Resource: "services"}
+	storageClassesGVR  = schema.GroupVersionResource{Group: "storage.k8s.io", Version: "v1", Resource: "storageclasses"}
+	rolesGVR           = schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "roles"}
+	clusterRolesGVR    = schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"}
+)
+
+// dependencyRef is an object referenced by a backed-up object. Namespace is
+// empty for cluster-scoped objects.
+type dependencyRef struct {
+	GVR       schema.GroupVersionResource
+	Namespace string
+	Name      string
+	Reason    string
+}
+
+// podSpecPaths locates the pod spec of workload kinds.
+var podSpecPaths = map[string][]string{
+	"Pod":                   {"spec"},
+	"Deployment":            {"spec", "template", "spec"},
+	"ReplicaSet":            {"spec", "template", "spec"},
+	"StatefulSet":           {"spec", "template", "spec"},
+	"DaemonSet":             {"spec", "template", "spec"},
+	"Job":                   {"spec", "template", "spec"},
+	"ReplicationController": {"spec", "template", "spec"},
+	"DeploymentConfig":      {"spec", "template", "spec"},
+	"CronJob":               {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// dependenciesOf lists the objects an object references by name: the
+// ConfigMaps, Secrets, PVCs and ServiceAccount of pod templates, Ingress and
+// Route backends, the role and service accounts of RoleBindings, and the
+// volume and storage class of PVCs and PVs.
+func dependenciesOf(item *unstructured.Unstructured) []dependencyRef {
+	namespace := item.GetNamespace()
+	owner := fmt.Sprintf("%s %s", item.GetKind(), item.GetName())
+	if namespace != "" {
+		owner = fmt.Sprintf("%s %s/%s", item.GetKind(), namespace, item.GetName())
+	}
+
+	var refs []dependencyRef
+	add := func(gvr schema.GroupVersionResource, refNamespace, name, via string) {
+		if name != "" {
+			refs = append(refs, dependencyRef{GVR: gvr, Namespace: refNamespace, Name: name, Reason: owner + " (" + via + ")"})
+		}
+	}
+	object := item.Object
+
+	if path, ok := podSpecPaths[item.GetKind()]; ok {
+		if podSpec, found, _ := unstructured.NestedMap(object, path...); found {
+			podSpecDependencies(podSpec, namespace, add)
+		}
+		return refs
+	}
+
+	switch item.GetKind() {
+	case "Ingress":
+		add(servicesGVR, namespace, nestedString(object, "spec", "defaultBackend", "service", "name"), "default backend")
+		add(servicesGVR, namespace, nestedString(object, "spec", "backend", "serviceName"), "default backend")
+		for _, rule := range nestedMaps(object, "spec", "rules") {
+			for _, path := range nestedMaps(rule, "http", "paths") {
+				add(servicesGVR, namespace, nestedString(path, "backend", "service", "name"), "backend")
+				add(servicesGVR, namespace, nestedString(path, "backend", "serviceName"), "backend")
+			}
+		}
+		for _, tls := range nestedMaps(object, "spec", "tls") {
+			add(secretsGVR, namespace, nestedString(tls, "secretName"), "tls")
+		}
+
+	case "Route":
+		if kind := nestedString(object, "spec", "to", "kind"); kind == "" || kind == "Service" {
+			add(servicesGVR, namespace, nestedString(object, "spec", "to", "name"), "backend")
+		}
+		for _, backend := range nestedMaps(object, "spec", "alternateBackends") {
+			add(servicesGVR, namespace, nestedString(backend, "name"), "alternate backend")
+		}
+
+	case "RoleBinding", "ClusterRoleBinding":
+		switch nestedString(object, "roleRef", "kind") {
+		case "Role":
+			add(rolesGVR, namespace, nestedString(object, "roleRef", "name"), "roleRef")
+		case "ClusterRole":
+			add(clusterRolesGVR, "", nestedString(object, "roleRef", "name"), "roleRef")
+		}
+		for _, subject := range nestedMaps(object, "subjects") {
+			if nestedString(subject, "kind") != "ServiceAccount" {
+				continue
+			}
+			subjectNamespace := nestedString(subject, "namespace")
+			if subjectNamespace == "" {
+				subjectNamespace = namespace
+			}
+			add(serviceAccountsGVR, subjectNamespace, nestedString(subject, "name"), "subject")
+		}
+
+	case "ServiceAccount":
+		for _, secret := range nestedMaps(object, "secrets") {
+			add(secretsGVR, namespace, nestedString(secret, "name"), "secret")
+		}
+		for _, secret := range nestedMaps(object, "imagePullSecrets") {
+			add(secretsGVR, namespace, nestedString(secret, "name"), "imagePullSecrets")
+		}
+
+	case "PersistentVolumeClaim":
+		add(pvsGVR, "", nestedString(object, "spec", "volumeName"), "volumeName")
+		add(storageClassesGVR, "", nestedString(object, "spec", "storageClassName"), "storageClassName")
+
+	case "PersistentVolume":
+		add(storageClassesGVR, "", nestedString(object, "spec", "storageClassName"), "storageClassName")
+	}
+	return refs
+}
+
+// podSpecDependencies adds the references of a pod spec: its service
+// account, image pull secrets, volumes and container environment.
+func podSpecDependencies(podSpec map[string]interface{}, namespace string, add func(schema.GroupVersionResource, string, string, string)) {
+	serviceAccount := nestedString(podSpec, "serviceAccountName")
+	if serviceAccount == "" {
+		serviceAccount = nestedString(podSpec, "serviceAccount")
+	}
+	// Every namespace gets a default service account
+	if serviceAccount != "default" {
+		add(serviceAccountsGVR, namespace, serviceAccount, "serviceAccountName")
+	}
+	for _, secret := range nestedMaps(podSpec, "imagePullSecrets") {
+		add(secretsGVR, namespace, nestedString(secret, "name"), "imagePullSecrets")
+	}
+
+	for _, volume := range nestedMaps(podSpec, "volumes") {
+		via := "volume " + nestedString(volume, "name")
+		add(configMapsGVR, namespace, nestedString(volume, "configMap", "name"), via)
+		add(secretsGVR, namespace, nestedString(volume, "secret", "secretName"), via)
+		add(pvcsGVR, namespace, nestedString(volume, "persistentVolumeClaim", "claimName"), via)
+		for _, source := range nestedMaps(volume, "projected", "sources") {
+			add(configMapsGVR, namespace, nestedString(source, "configMap", "name"), via)
+			add(secretsGVR, namespace, nestedString(source, "secret", "name"), via)
+		}
+	}
+
+	for _, field := range []string{"initContainers", "containers", "ephemeralContainers"} {
+		for _, container := range nestedMaps(podSpec, field) {
+			via := "container " + nestedString(container, "name")
+			for _, envFrom := range nestedMaps(container, "envFrom") {
+				add(configMapsGVR, namespace, nestedString(envFrom, "configMapRef", "name"), via+" envFrom")
+				add(secretsGVR, namespace, nestedString(envFrom, "secretRef", "name"), via+" envFrom")
+			}
+			for _, env := range nestedMaps(container, "env") {
+				add(configMapsGVR, namespace, nestedString(env, "valueFrom", "configMapKeyRef", "name"), via+" env "+nestedString(env, "name"))
+				add(secretsGVR, namespace, nestedString(env, "valueFrom", "secretKeyRef", "name"), via+" env "+nestedString(env, "name"))
+			}
+		}
+	}
+}
+
+func nestedString(object map[string]interface{}, fields ...string) string {
+	value, _, _ := unstructured.NestedString(object, fields...)
+	return value
+}
+
+// nestedMaps returns the map elements of a list field.
+func nestedMaps(object map[string]interface{}, fields ...string) []map[string]interface{} {
+	list, _, _ := unstructured.NestedSlice(object, fields...)
+	maps := make([]map[string]interface{}, 0, len(list))
+	for _, element := range list {
+		if m, ok := element.(map[string]interface{}); ok {
+			maps = append(maps, m)
+		}
+	}
+	return maps
+}
+
+// queueDependencies remembers the references of a backed-up object until
+// its namespace is complete.
+func (cb *ClusterBackup) queueDependencies(item *unstructured.Unstructured) {
+	if cb.backupConfig.IncludeDependencies {
+		cb.dependencyQueue = append(cb.dependencyQueue, dependenciesOf(item)...)
+	}
+}
+
+// outsideSelection reports whether a reference points into a namespace the
+// run does not back up, such as a RoleBinding subject in another team's
+// namespace. References are followed only within the namespace selection.
+func (cb *ClusterBackup) outsideSelection(ref dependencyRef) bool {
+	return ref.Namespace != "" && cb.selectedNamespaces != nil && !cb.selectedNamespaces[ref.Namespace]
+}
+
+// backupDependencies backs up the queued references that the selection did
+// not include, and transitively what they reference. Cluster-scoped objects
+// are stored below namespace, like the cluster-scoped objects of the main
+// selection. Each object's manifest entry records why it was included.
+func (cb *ClusterBackup) backupDependencies(namespace string) (int, error) {
+	queue := cb.dependencyQueue
+	cb.dependencyQueue = nil
+
+	count := 0
+	var errors []string
+	for len(queue) > 0 {
+		ref := queue[0]
+		queue = queue[1:]
+
+		if cb.outsideSelection(ref) {
+			cb.recordWarning("DependencyOutsideSelection", fmt.Sprintf("Not backing up %s %s/%s referenced by %s: its namespace is not selected", ref.GVR.Resource, ref.Namespace, ref.Name, ref.Reason))
+			continue
+		}
+
+		storeNamespace := ref.Namespace
+		if storeNamespace == "" {
+			storeNamespace = namespace
+		}
+		key := cb.objectKey(storeNamespace, ref.GVR.Resource, ref.Name)
+		if cb.manifestHas(key) {
+			continue
+		}
+
+		var item *unstructured.Unstructured
+		var err error
+		if ref.Namespace != "" {
+			item, err = cb.dynamicClient.Resource(ref.GVR).Namespace(ref.Namespace).Get(cb.ctx, ref.Name, metav1.GetOptions{})
+		} else {
+			item, err = cb.dynamicClient.Resource(ref.GVR).Get(cb.ctx, ref.Name, metav1.GetOptions{})
+		}
+		switch {
+		case apierrors.IsNotFound(err):
+			// Optional references may point at objects that do not exist
+			cb.logger.Warn("dependency_missing", "Referenced object does not exist", map[string]interface{}{
+				"namespace":     ref.Namespace,
+				"resource_type": ref.GVR.Resource,
+				"resource_name": ref.Name,
+				"referenced_by": ref.Reason,
+			})
+			continue
+		case apierrors.IsForbidden(err):
+			cb.recordWarning("DependencyForbidden", fmt.Sprintf("Not allowed to read %s %s referenced by %s", ref.GVR.GroupResource(), ref.Name, ref.Reason))
+			continue
+		case err != nil:
+			errors = append(errors, fmt.Sprintf("get %s %s: %v", ref.GVR.Resource, ref.Name, err))
+			continue
+		}
+
+		if cb.excludedByAnnotation(item) || cb.skipOpenShiftGenerated(item) {
+			continue
+		}
+
+		if err := cb.uploadResource(storeNamespace, ref.GVR, item, cb.cleanResource(item)); err != nil {
+			errors = append(errors, fmt.Sprintf("upload %s %s: %v", ref.GVR.Resource, ref.Name, err))
+			continue
+		}
+		cb.markIncludedBy(key, ref.Reason)
+		cb.metrics.ResourcesBackedUp.Inc()
+		cb.trackHelmResource(item)
+		count++
+
+		cb.logger.Debug("dependency_uploaded", "Referenced object included in backup", map[string]interface{}{
+			"namespace":     ref.Namespace,
+			"resource_type": ref.GVR.Resource,
+			"resource_name": ref.Name,
+			"referenced_by": ref.Reason,
+		})
+		queue = append(queue, dependenciesOf(item)...)
+	}
+
+	if len(errors) > 0 {
+		return count, fmt.Errorf("%d dependencies failed, first: %s", len(errors), errors[0])
+	}
+	return count, nil
+}
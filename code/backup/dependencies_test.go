@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDependenciesOf(t *testing.T) {
+	tests := []struct {
+		name   string
+		object map[string]interface{}
+		want   []dependencyRef
+	}{
+		{
+			name: "deployment pod template",
+			object: map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"metadata":   map[string]interface{}{"name": "web", "namespace": "shop"},
+				"spec": map[string]interface{}{"template": map[string]interface{}{"spec": map[string]interface{}{
+					"serviceAccountName": "web",
+					"volumes": []interface{}{
+						map[string]interface{}{"name": "config", "configMap": map[string]interface{}{"name": "web-config"}},
+						map[string]interface{}{"name": "data", "persistentVolumeClaim": map[string]interface{}{"claimName": "web-data"}},
+					},
+					"containers": []interface{}{
+						map[string]interface{}{
+							"name": "web",
+							"env": []interface{}{
+								map[string]interface{}{"name": "TOKEN", "valueFrom": map[string]interface{}{"secretKeyRef": map[string]interface{}{"name": "web-token"}}},
+								map[string]interface{}{"name": "MODE", "value": "production"},
+							},
+						},
+					},
+				}}},
+			},
+			want: []dependencyRef{
+				{GVR: serviceAccountsGVR, Namespace: "shop", Name: "web", Reason: "Deployment shop/web (serviceAccountName)"},
+				{GVR: configMapsGVR, Namespace: "shop", Name: "web-config", Reason: "Deployment shop/web (volume config)"},
+				{GVR: pvcsGVR, Namespace: "shop", Name: "web-data", Reason: "Deployment shop/web (volume data)"},
+				{GVR: secretsGVR, Namespace: "shop", Name: "web-token", Reason: "Deployment shop/web (container web env TOKEN)"},
+			},
+		},
+		{
+			name: "default service account is not followed",
+			object: map[string]interface{}{
+				"kind":     "Pod",
+				"metadata": map[string]interface{}{"name": "debug", "namespace": "shop"},
+				"spec":     map[string]interface{}{"serviceAccountName": "default"},
+			},
+			want: nil,
+		},
+		{
+			name: "role binding subjects default to the binding's namespace",
+			object: map[string]interface{}{
+				"kind":     "RoleBinding",
+				"metadata": map[string]interface{}{"name": "deployers", "namespace": "shop"},
+				"roleRef":  map[string]interface{}{"kind": "ClusterRole", "name": "edit"},
+				"subjects": []interface{}{
+					map[string]interface{}{"kind": "ServiceAccount", "name": "ci"},
+					map[string]interface{}{"kind": "ServiceAccount", "name": "argocd", "namespace": "argocd"},
+					map[string]interface{}{"kind": "User", "name": "alice"},
+				},
+			},
+			want: []dependencyRef{
+				{GVR: clusterRolesGVR, Name: "edit", Reason: "RoleBinding shop/deployers (roleRef)"},
+				{GVR: serviceAccountsGVR, Namespace: "shop", Name: "ci", Reason: "RoleBinding shop/deployers (subject)"},
+				{GVR: serviceAccountsGVR, Namespace: "argocd", Name: "argocd", Reason: "RoleBinding shop/deployers (subject)"},
+			},
+		},
+		{
+			name: "persistent volume claim",
+			object: map[string]interface{}{
+				"kind":     "PersistentVolumeClaim",
+				"metadata": map[string]interface{}{"name": "web-data", "namespace": "shop"},
+				"spec":     map[string]interface{}{"volumeName": "pv-1", "storageClassName": "fast"},
+			},
+			want: []dependencyRef{
+				{GVR: pvsGVR, Name: "pv-1", Reason: "PersistentVolumeClaim shop/web-data (volumeName)"},
+				{GVR: storageClassesGVR, Name: "fast", Reason: "PersistentVolumeClaim shop/web-data (storageClassName)"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := dependenciesOf(&unstructured.Unstructured{Object: tt.object})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dependenciesOf =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutsideSelection(t *testing.T) {
+	cb := &ClusterBackup{selectedNamespaces: map[string]bool{"shop": true}}
+	tests := []struct {
+		ref  dependencyRef
+		want bool
+	}{
+		{dependencyRef{GVR: serviceAccountsGVR, Namespace: "shop", Name: "ci"}, false},
+		{dependencyRef{GVR: serviceAccountsGVR, Namespace: "argocd", Name: "argocd"}, true},
+		{dependencyRef{GVR: clusterRolesGVR, Name: "edit"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := cb.outsideSelection(tt.ref); got != tt.want {
+			t.Errorf("outsideSelection(%s/%s) = %v, want %v", tt.ref.Namespace, tt.ref.Name, got, tt.want)
+		}
+	}
+}
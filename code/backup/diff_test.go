@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+	}{
+		{
+			name: "equal",
+			from: "a\nb\n",
+			to:   "a\nb\n",
+			want: "",
+		},
+		{
+			name: "changed line with context",
+			from: "a\nb\nc\n",
+			to:   "a\nB\nc\n",
+			want: "--- from\n+++ to\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name: "added to empty",
+			from: "",
+			to:   "x\n",
+			want: "--- from\n+++ to\n@@ -0,0 +1 @@\n+x\n",
+		},
+		{
+			name: "distant changes get separate hunks",
+			from: "l1\nl2\nl3\nl4\nl5\nl6\nl7\nl8\nl9\nl10\n",
+			to:   "L1\nl2\nl3\nl4\nl5\nl6\nl7\nl8\nl9\nL10\n",
+			want: "--- from\n+++ to\n" +
+				"@@ -1,4 +1,4 @@\n-l1\n+L1\n l2\n l3\n l4\n" +
+				"@@ -7,4 +7,4 @@\n l7\n l8\n l9\n-l10\n+L10\n",
+		},
+		{
+			name: "nearby changes share a hunk",
+			from: "a\nb\nc\nd\n",
+			to:   "A\nb\nc\nD\n",
+			want: "--- from\n+++ to\n@@ -1,4 +1,4 @@\n-a\n+A\n b\n c\n-d\n+D\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff(tt.from, tt.to, "from", "to"); got != tt.want {
+				t.Errorf("unifiedDiff =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
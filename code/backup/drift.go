@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// DriftEntry is one object that differs between the live cluster and the
+// last backup.
+type DriftEntry struct {
+	Namespace string `json:"namespace"`
+	Resource  string `json:"resource"`
+	Name      string `json:"name"`
+	Key       string `json:"key"`
+	Diff      string `json:"diff,omitempty"`
+}
+
+// DriftCounts summarizes the drift of one namespace.
+type DriftCounts struct {
+	Added    int `json:"added"`
+	Modified int `json:"modified"`
+	Deleted  int `json:"deleted"`
+}
+
+// DriftReport compares the live cluster with the stored backup. Added objects
+// exist only in the cluster, deleted ones only in the backup.
+type DriftReport struct {
+	Cluster     string                 `json:"cluster"`
+	Baseline    string                 `json:"baseline"`
+	GeneratedAt time.Time              `json:"generated_at"`
+	Added       []DriftEntry           `json:"added"`
+	Modified    []DriftEntry           `json:"modified"`
+	Deleted     []DriftEntry           `json:"deleted"`
+	Namespaces  map[string]DriftCounts `json:"namespaces"`
+}
+
+// latestBackupPrefix returns the prefix holding the most recent backup: the
+// newest complete snapshot in snapshot mode, the flat layout otherwise.
+func (cb *ClusterBackup) latestBackupPrefix() (string, string, error) {
+	if !cb.backupConfig.SnapshotMode {
+		return cb.clusterPrefix(), "flat", nil
+	}
+	snapshots, err := cb.listSnapshots()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to list snapshots: %v", err)
+	}
+	if snapshot, ok := newestCompleteSnapshot(snapshots, time.Time{}); ok {
+		return snapshot.Prefix, snapshot.RunID, nil
+	}
+	return "", "", fmt.Errorf("no complete snapshots stored for cluster %s", cb.config.ClusterName)
+}
+
+// listStoredObjects returns the objects below prefix keyed by their path
+// relative to it, {namespace}/{resource-type}/{name}.yaml. Listing the flat
+// layout skips the snapshot prefixes.
+func (cb *ClusterBackup) listStoredObjects(prefix string) (map[string]minio.ObjectInfo, error) {
+	objects := make(map[string]minio.ObjectInfo)
+	for object := range cb.minioClient.ListObjects(cb.ctx, cb.config.MinIOBucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		if prefix == cb.clusterPrefix() && strings.HasPrefix(object.Key, cb.snapshotsPrefix()) {
+			continue
+		}
+		objects[strings.TrimPrefix(object.Key, prefix)] = object
+	}
+	return objects, nil
+}
+
+// readStoredYAML reads a stored object and re-serializes it so it compares
+// equal to a freshly marshalled live object with the same content.
+func (cb *ClusterBackup) readStoredYAML(key string) (string, error) {
+	object, err := cb.minioClient.GetObject(cb.ctx, cb.config.MinIOBucket, key, cb.getObjectOptions())
+	if err != nil {
+		return "", err
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(object)
+	if err != nil {
+		return "", err
+	}
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal(data, &parsed); err != nil {
+		return "", fmt.Errorf("failed to parse %s: %v", key, err)
+	}
+	normalized, err := yaml.Marshal(parsed)
+	if err != nil {
+		return "", err
+	}
+	return string(normalized), nil
+}
+
+// splitRelativeKey splits {namespace}/{resource-type}/{name}.yaml.
+func splitRelativeKey(rel string) (string, string, string, bool) {
+	parts := strings.Split(rel, "/")
+	if len(parts) != 3 || !strings.HasSuffix(parts[2], ".yaml") {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], strings.TrimSuffix(parts[2], ".yaml"), true
+}
+
+// driftComparesType reports whether drift reads the live objects of a
+// stored resource type.
+func (cb *ClusterBackup) driftComparesType(resourceType string) bool {
+	for _, gvr := range []schema.GroupVersionResource{namespacesGVR, projectsGVR, resourceQuotasGVR, limitRangesGVR} {
+		if gvr.Resource == resourceType {
+			return cb.backupConfig.NamespaceMetadata || !cb.skipInResourceLoop(gvr)
+		}
+	}
+	return true
+}
+
+// computeDrift lists the resources a backup would select, cleans them the
+// same way and compares them with the last stored backup.
+func (cb *ClusterBackup) computeDrift(onlyNamespace string) (*DriftReport, error) {
+	prefix, baseline, err := cb.latestBackupPrefix()
+	if err != nil {
+		return nil, err
+	}
+	stored, err := cb.listStoredObjects(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stored objects: %v", err)
+	}
+
+	apiResources, err := cb.getAPIResources()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API resources: %v", err)
+	}
+	namespaces, err := cb.getNamespacesToBackup()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get namespaces: %v", err)
+	}
+	if onlyNamespace != "" {
+		namespaces = []string{onlyNamespace}
+	}
+
+	report := &DriftReport{
+		Cluster:     cb.config.ClusterName,
+		Baseline:    baseline,
+		GeneratedAt: time.Now().UTC(),
+		Added:       []DriftEntry{},
+		Modified:    []DriftEntry{},
+		Deleted:     []DriftEntry{},
+		Namespaces:  make(map[string]DriftCounts),
+	}
+
+	resourceTypes := make(map[string]bool)
+	for _, resource := range apiResources {
+		resourceTypes[resource.Name] = true
+	}
+	selectedNamespaces := make(map[string]bool)
+	seen := make(map[string]bool)
+	// Types that could not be listed are left out of the deleted list
+	listFailed := make(map[string]bool)
+
+	compare := func(namespace, resourceType string, item *unstructured.Unstructured) {
+		cleaned := cb.cleanResource(item)
+		live, err := yaml.Marshal(cleaned)
+		if err != nil {
+			return
+		}
+
+		rel := fmt.Sprintf("%s/%s/%s.yaml", namespace, resourceType, item.GetName())
+		seen[rel] = true
+		entry := DriftEntry{Namespace: namespace, Resource: resourceType, Name: item.GetName(), Key: prefix + rel}
+
+		object, ok := stored[rel]
+		if !ok {
+			report.Added = append(report.Added, entry)
+			return
+		}
+		storedYAML, err := cb.readStoredYAML(object.Key)
+		if err != nil {
+			cb.logger.Warn("drift_read_failed", "Failed to read stored object", map[string]interface{}{
+				"object_key": object.Key,
+				"error":      err.Error(),
+			})
+			return
+		}
+		if diff := unifiedDiff(storedYAML, string(live), "backup/"+rel, "live/"+rel); diff != "" {
+			entry.Diff = diff
+			report.Modified = append(report.Modified, entry)
+		}
+	}
+
+	for _, namespace := range namespaces {
+		selectedNamespaces[namespace] = true
+
+		// Namespace objects are read the way backupNamespaceMetadata reads
+		// them, the resource loop skips their types
+		if cb.backupConfig.NamespaceMetadata {
+			items, gvrs, err := cb.namespaceMetadataItems(namespace)
+			if err != nil {
+				for _, gvr := range []schema.GroupVersionResource{namespacesGVR, projectsGVR, resourceQuotasGVR, limitRangesGVR} {
+					listFailed[namespace+"/"+gvr.Resource] = true
+				}
+			}
+			for i, item := range items {
+				if !cb.excludedByAnnotation(item) {
+					compare(namespace, gvrs[i].Resource, item)
+				}
+			}
+		}
+
+		for _, resource := range apiResources {
+			if cb.skipInResourceLoop(resourceGVR(resource)) {
+				continue
+			}
+			items, err := cb.listResourceItems(namespace, resourceGVR(resource), resource)
+			if err != nil {
+				listFailed[namespace+"/"+resource.Name] = true
+				continue
+			}
+
+			for i := range items.Items {
+				item := &items.Items[i]
+				if cb.shouldSkipResource(item) {
+					continue
+				}
+				compare(namespace, resource.Name, item)
+			}
+		}
+	}
+
+	for rel, object := range stored {
+		namespace, resourceType, name, ok := splitRelativeKey(rel)
+		if !ok || seen[rel] || !selectedNamespaces[namespace] || !resourceTypes[resourceType] || listFailed[namespace+"/"+resourceType] {
+			continue
+		}
+		// Types neither listed by the resource loop nor read as namespace
+		// objects cannot be told apart from deleted ones
+		if !cb.driftComparesType(resourceType) {
+			continue
+		}
+		report.Deleted = append(report.Deleted, DriftEntry{Namespace: namespace, Resource: resourceType, Name: name, Key: object.Key})
+	}
+
+	for _, list := range [][]DriftEntry{report.Added, report.Modified, report.Deleted} {
+		sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	}
+	for _, entry := range report.Added {
+		counts := report.Namespaces[entry.Namespace]
+		counts.Added++
+		report.Namespaces[entry.Namespace] = counts
+	}
+	for _, entry := range report.Modified {
+		counts := report.Namespaces[entry.Namespace]
+		counts.Modified++
+		report.Namespaces[entry.Namespace] = counts
+	}
+	for _, entry := range report.Deleted {
+		counts := report.Namespaces[entry.Namespace]
+		counts.Deleted++
+		report.Namespaces[entry.Namespace] = counts
+	}
+
+	return report, nil
+}
+
+// updateDriftMetrics publishes the drifted object counts per namespace.
+func (cb *ClusterBackup) updateDriftMetrics(report *DriftReport) {
+	cb.metrics.DriftObjects.Reset()
+	for namespace, counts := range report.Namespaces {
+		cb.metrics.DriftObjects.WithLabelValues(namespace, "added").Set(float64(counts.Added))
+		cb.metrics.DriftObjects.WithLabelValues(namespace, "modified").Set(float64(counts.Modified))
+		cb.metrics.DriftObjects.WithLabelValues(namespace, "deleted").Set(float64(counts.Deleted))
+	}
+}
+
+// writeDriftReport renders a drift report as text or JSON.
+func writeDriftReport(w io.Writer, report *DriftReport, format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	fmt.Fprintf(w, "Drift of cluster %s against backup %s: %d added, %d modified, %d deleted\n",
+		report.Cluster, report.Baseline, len(report.Added), len(report.Modified), len(report.Deleted))
+	for _, entry := range report.Added {
+		fmt.Fprintf(w, "+ %s/%s/%s\n", entry.Namespace, entry.Resource, entry.Name)
+	}
+	for _, entry := range report.Deleted {
+		fmt.Fprintf(w, "- %s/%s/%s\n", entry.Namespace, entry.Resource, entry.Name)
+	}
+	for _, entry := range report.Modified {
+		fmt.Fprintf(w, "~ %s/%s/%s\n%s", entry.Namespace, entry.Resource, entry.Name, entry.Diff)
+	}
+	return nil
+}
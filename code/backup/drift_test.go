@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestDriftComparesType(t *testing.T) {
+	tests := []struct {
+		resourceType      string
+		namespaceMetadata bool
+		want              bool
+	}{
+		{"namespaces", true, true},
+		{"projects", true, true},
+		{"resourcequotas", true, true},
+		{"limitranges", true, true},
+		{"namespaces", false, false},
+		{"projects", false, false},
+		{"resourcequotas", false, true},
+		{"limitranges", false, true},
+		{"configmaps", true, true},
+		{"configmaps", false, true},
+	}
+
+	for _, tt := range tests {
+		cb := &ClusterBackup{backupConfig: &BackupConfig{NamespaceMetadata: tt.namespaceMetadata}}
+		if got := cb.driftComparesType(tt.resourceType); got != tt.want {
+			t.Errorf("driftComparesType(%s) with namespace-metadata=%v = %v, want %v", tt.resourceType, tt.namespaceMetadata, got, tt.want)
+		}
+	}
+}
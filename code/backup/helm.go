@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Annotations Helm 3 sets on every object of a release.
+const (
+	helmReleaseNameAnnotation      = "meta.helm.sh/release-name"
+	helmReleaseNamespaceAnnotation = "meta.helm.sh/release-namespace"
+)
+
+// helmReleaseResource is the resource-type segment release summaries are
+// stored under, {namespace}/helm-releases/{release}.yaml. No API resource
+// uses it, so drift and restore can tell summaries apart from objects.
+const helmReleaseResource = "helm-releases"
+
+// helmSummaryGVR identifies release summaries in keys and metadata.
+var helmSummaryGVR = schema.GroupVersionResource{Group: "clusterbackup.io", Version: "v1", Resource: helmReleaseResource}
+
+// helmRelease is the part of Helm's release record kept in the summary.
+type helmRelease struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Version   int    `json:"version"`
+	Info      struct {
+		Status        string `json:"status"`
+		FirstDeployed string `json:"first_deployed"`
+		LastDeployed  string `json:"last_deployed"`
+		Description   string `json:"description"`
+	} `json:"info"`
+	Chart struct {
+		Metadata struct {
+			Name       string `json:"name"`
+			Version    string `json:"version"`
+			AppVersion string `json:"appVersion"`
+		} `json:"metadata"`
+	} `json:"chart"`
+	// Config holds the values supplied by the user, not the chart defaults
+	Config map[string]interface{} `json:"config"`
+
+	// annotations of the Secret or ConfigMap storing the release, where
+	// backup.cluster/ overrides of the release are set
+	annotations map[string]string
+}
+
+// helmResourceRef is one backed-up object belonging to a release.
+type helmResourceRef struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Namespace  string `yaml:"namespace,omitempty"`
+	Name       string `yaml:"name"`
+}
+
+// helmReleaseOf returns the namespace and name of the release an object
+// belongs to, or empty strings.
+func helmReleaseOf(item *unstructured.Unstructured) (string, string) {
+	annotations := item.GetAnnotations()
+	name := annotations[helmReleaseNameAnnotation]
+	if name == "" {
+		return "", ""
+	}
+	namespace := annotations[helmReleaseNamespaceAnnotation]
+	if namespace == "" {
+		namespace = item.GetNamespace()
+	}
+	return namespace, name
+}
+
+// trackHelmResource remembers a backed-up object for the summary of its
+// release.
+func (cb *ClusterBackup) trackHelmResource(item *unstructured.Unstructured) {
+	namespace, name := helmReleaseOf(item)
+	if name == "" {
+		return
+	}
+	key := namespace + "/" + name
+	if cb.helmResources == nil {
+		cb.helmResources = make(map[string][]helmResourceRef)
+	}
+	cb.helmResources[key] = append(cb.helmResources[key], helmResourceRef{
+		APIVersion: item.GetAPIVersion(),
+		Kind:       item.GetKind(),
+		Namespace:  item.GetNamespace(),
+		Name:       item.GetName(),
+	})
+}
+
+// decodeHelmRelease decodes the release field of a Helm storage Secret or
+// ConfigMap: base64 of the, usually gzipped, JSON release record.
+func decodeHelmRelease(encoded string) (*helmRelease, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %v", err)
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip: %v", err)
+		}
+		defer reader.Close()
+		if data, err = io.ReadAll(reader); err != nil {
+			return nil, fmt.Errorf("invalid gzip: %v", err)
+		}
+	}
+
+	var release helmRelease
+	if err := json.Unmarshal(data, &release); err != nil {
+		return nil, fmt.Errorf("invalid release record: %v", err)
+	}
+	return &release, nil
+}
+
+// latestHelmReleases returns the newest revision of every release stored in
+// a namespace, from Secrets (Helm's default driver) and ConfigMaps.
+func (cb *ClusterBackup) latestHelmReleases(namespace string) (map[string]*helmRelease, error) {
+	type storedRelease struct {
+		revision    int
+		encoded     string
+		annotations map[string]string
+	}
+	latest := make(map[string]storedRelease)
+	consider := func(meta metav1.ObjectMeta, encoded string) {
+		labels := meta.Labels
+		revision, err := strconv.Atoi(labels["version"])
+		if err != nil || labels["name"] == "" || encoded == "" {
+			return
+		}
+		if current, ok := latest[labels["name"]]; !ok || revision > current.revision {
+			latest[labels["name"]] = storedRelease{revision: revision, encoded: encoded, annotations: meta.Annotations}
+		}
+	}
+
+	listOptions := metav1.ListOptions{LabelSelector: "owner=helm"}
+	secrets, err := cb.kubeClient.CoreV1().Secrets(namespace).List(cb.ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list release secrets: %v", err)
+	}
+	for _, secret := range secrets.Items {
+		if secret.Type == "helm.sh/release.v1" {
+			consider(secret.ObjectMeta, string(secret.Data["release"]))
+		}
+	}
+	configMaps, err := cb.kubeClient.CoreV1().ConfigMaps(namespace).List(cb.ctx, listOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list release configmaps: %v", err)
+	}
+	for _, configMap := range configMaps.Items {
+		consider(configMap.ObjectMeta, configMap.Data["release"])
+	}
+
+	releases := make(map[string]*helmRelease, len(latest))
+	for name, stored := range latest {
+		release, err := decodeHelmRelease(stored.encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode release %s revision %d: %v", name, stored.revision, err)
+		}
+		release.annotations = stored.annotations
+		releases[name] = release
+	}
+	return releases, nil
+}
+
+// helmReleaseSummary builds the stored summary of a release. It is shaped
+// like a Kubernetes object so verification and diffs treat it like any other
+// stored object; restore skips it.
+func helmReleaseSummary(release *helmRelease, resources []helmResourceRef) map[string]interface{} {
+	sort.Slice(resources, func(i, j int) bool {
+		a, b := resources[i], resources[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	values := release.Config
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"apiVersion": helmSummaryGVR.GroupVersion().String(),
+		"kind":       "HelmReleaseSummary",
+		"metadata": map[string]interface{}{
+			"name":      release.Name,
+			"namespace": release.Namespace,
+		},
+		"release": map[string]interface{}{
+			"revision":      release.Version,
+			"status":        release.Info.Status,
+			"firstDeployed": release.Info.FirstDeployed,
+			"lastDeployed":  release.Info.LastDeployed,
+			"description":   release.Info.Description,
+		},
+		"chart": map[string]interface{}{
+			"name":       release.Chart.Metadata.Name,
+			"version":    release.Chart.Metadata.Version,
+			"appVersion": release.Chart.Metadata.AppVersion,
+		},
+		"values":    values,
+		"resources": resources,
+	}
+}
+
+// redactValues replaces every value of a release's values with an empty
+// string, keeping the keys so a restore shows what has to be filled in.
+func redactValues(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(value))
+		for key, nested := range value {
+			redacted[key] = redactValues(nested)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(value))
+		for i, nested := range value {
+			redacted[i] = redactValues(nested)
+		}
+		return redacted
+	default:
+		return ""
+	}
+}
+
+// backupHelmReleases stores a summary of the latest revision of every Helm
+// release in the backed-up namespaces, listing the objects of the release
+// this run captured. It returns the number of summaries written.
+func (cb *ClusterBackup) backupHelmReleases(namespaces []string) (int, error) {
+	written := 0
+	var errors []string
+	for _, namespace := range namespaces {
+		releases, err := cb.latestHelmReleases(namespace)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("%s: %v", namespace, err))
+			continue
+		}
+
+		names := make([]string, 0, len(releases))
+		for name := range releases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			release := releases[name]
+			if release.Namespace == "" {
+				release.Namespace = namespace
+			}
+			// Overrides of the release's storage object or its namespace
+			// apply to the summary, whose values may hold secrets
+			item := &unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": helmSummaryGVR.GroupVersion().String(),
+				"kind":       "HelmReleaseSummary",
+			}}
+			item.SetName(release.Name)
+			item.SetNamespace(release.Namespace)
+			item.SetAnnotations(release.annotations)
+			if cb.excludedByAnnotation(item) {
+				continue
+			}
+			summary := helmReleaseSummary(release, cb.helmResources[namespace+"/"+name])
+			if value, source := cb.override(item, overrideRedact); value == "true" {
+				cb.logOverride(item, overrideRedact, value, source)
+				summary["values"] = redactValues(summary["values"])
+			}
+			if err := cb.uploadResource(namespace, helmSummaryGVR, item, summary); err != nil {
+				errors = append(errors, fmt.Sprintf("%s/%s: %v", namespace, name, err))
+				continue
+			}
+			written++
+			cb.logger.Debug("helm_release_stored", "Helm release summary stored", map[string]interface{}{
+				"namespace":     namespace,
+				"release":       name,
+				"chart":         release.Chart.Metadata.Name,
+				"chart_version": release.Chart.Metadata.Version,
+				"revision":      release.Version,
+				"resources":     len(cb.helmResources[namespace+"/"+name]),
+			})
+		}
+	}
+
+	if len(errors) > 0 {
+		return written, fmt.Errorf("%d helm releases failed, first: %s", len(errors), errors[0])
+	}
+	return written, nil
+}
+
+// isHelmReleaseSummaryKey reports whether a key relative to a backup prefix
+// holds a release summary.
+func isHelmReleaseSummaryKey(rel string) bool {
+	_, resourceType, _, ok := splitRelativeKey(rel)
+	return ok && resourceType == helmReleaseResource
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+func TestRedactValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		values interface{}
+		want   interface{}
+	}{
+		{"empty", map[string]interface{}{}, map[string]interface{}{}},
+		{
+			name: "nested keys are kept",
+			values: map[string]interface{}{
+				"replicas": int64(3),
+				"database": map[string]interface{}{"password": "s3cret", "port": float64(5432), "tls": true},
+			},
+			want: map[string]interface{}{
+				"replicas": "",
+				"database": map[string]interface{}{"password": "", "port": "", "tls": ""},
+			},
+		},
+		{
+			name:   "lists",
+			values: map[string]interface{}{"users": []interface{}{map[string]interface{}{"name": "admin", "token": "abc"}, "plain"}},
+			want:   map[string]interface{}{"users": []interface{}{map[string]interface{}{"name": "", "token": ""}, ""}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactValues(tt.values); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("redactValues = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeHelmRelease(t *testing.T) {
+	record := `{"name":"web","namespace":"shop","version":7,` +
+		`"info":{"status":"deployed","last_deployed":"2024-05-02T10:11:12Z"},` +
+		`"chart":{"metadata":{"name":"web","version":"1.2.0","appVersion":"1.5"}},` +
+		`"config":{"replicas":3}}`
+
+	var gzipped bytes.Buffer
+	writer := gzip.NewWriter(&gzipped)
+	writer.Write([]byte(record))
+	writer.Close()
+
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr bool
+	}{
+		{"gzipped like the Helm storage drivers", base64.StdEncoding.EncodeToString(gzipped.Bytes()), false},
+		{"plain JSON", base64.StdEncoding.EncodeToString([]byte(record)), false},
+		{"not base64", "%%%", true},
+		{"truncated gzip", base64.StdEncoding.EncodeToString(gzipped.Bytes()[:12]), true},
+		{"not a release record", base64.StdEncoding.EncodeToString([]byte("[1, 2]")), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release, err := decodeHelmRelease(tt.encoded)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decodeHelmRelease succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeHelmRelease: %v", err)
+			}
+			if release.Name != "web" || release.Namespace != "shop" || release.Version != 7 {
+				t.Errorf("release = %s/%s v%d, want shop/web v7", release.Namespace, release.Name, release.Version)
+			}
+			if release.Info.Status != "deployed" || release.Chart.Metadata.Version != "1.2.0" || release.Chart.Metadata.AppVersion != "1.5" {
+				t.Errorf("release info = %+v, chart = %+v", release.Info, release.Chart.Metadata)
+			}
+			if !reflect.DeepEqual(release.Config, map[string]interface{}{"replicas": float64(3)}) {
+				t.Errorf("config = %v", release.Config)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var clusterVersionGVR = schema.GroupVersionResource{Group: "config.openshift.io", Version: "v1", Resource: "clusterversions"}
+
+// Sources of the cluster ID.
+const (
+	clusterIDSourceClusterVersion = "openshift-clusterversion"
+	clusterIDSourceKubeSystem     = "kube-system-uid"
+)
+
+// ClusterMarker records which cluster owns the clusterbackup/{cluster}/
+// prefix. It is written by the first backup and checked before every backup
+// and cleanup, so two clusters configured with the same CLUSTER_NAME cannot
+// overwrite or clean each other's data.
+//
+// KubeSystemUID is recorded next to the ClusterVersion ID, so the marker still
+// matches when the ClusterVersion cannot be read later, and the other way
+// round.
+type ClusterMarker struct {
+	ClusterName       string    `json:"cluster_name"`
+	ClusterID         string    `json:"cluster_id"`
+	IDSource          string    `json:"id_source"`
+	KubeSystemUID     string    `json:"kube_system_uid,omitempty"`
+	ClaimedAt         time.Time `json:"claimed_at"`
+	PreviousClusterID string    `json:"previous_cluster_id,omitempty"`
+}
+
+// clusterIdentity is the ID detected for this cluster, where it came from,
+// and the kube-system UID, which is always read.
+type clusterIdentity struct {
+	ID            string
+	Source        string
+	KubeSystemUID string
+}
+
+// matches reports whether a marker was written by this cluster, whichever of
+// its IDs the marker holds.
+func (id clusterIdentity) matches(marker ClusterMarker) bool {
+	switch {
+	case marker.ClusterID == id.ID, marker.ClusterID == id.KubeSystemUID:
+		return true
+	case marker.KubeSystemUID != "" && marker.KubeSystemUID == id.KubeSystemUID:
+		return true
+	}
+	return false
+}
+
+func (cb *ClusterBackup) clusterMarkerKey() string {
+	return cb.metaPrefix() + "cluster.json"
+}
+
+// detectClusterID derives a stable ID of the cluster: the clusterID of the
+// OpenShift ClusterVersion, or the UID of the kube-system namespace, which
+// lives as long as the cluster. Falling back to the UID on OpenShift is
+// reported, as the ID then depends on RBAC.
+func (cb *ClusterBackup) detectClusterID() (clusterIdentity, error) {
+	namespace, err := cb.kubeClient.CoreV1().Namespaces().Get(cb.ctx, "kube-system", metav1.GetOptions{})
+	if err != nil {
+		return clusterIdentity{}, fmt.Errorf("failed to read kube-system namespace: %v", err)
+	}
+	identity := clusterIdentity{
+		ID:            string(namespace.UID),
+		Source:        clusterIDSourceKubeSystem,
+		KubeSystemUID: string(namespace.UID),
+	}
+	if !cb.isOpenShift() {
+		return identity, nil
+	}
+
+	version, err := cb.dynamicClient.Resource(clusterVersionGVR).Get(cb.ctx, "version", metav1.GetOptions{})
+	reason := ""
+	switch {
+	case err == nil:
+		if id := nestedString(version.Object, "spec", "clusterID"); id != "" {
+			identity.ID, identity.Source = id, clusterIDSourceClusterVersion
+			return identity, nil
+		}
+		reason = "ClusterVersion has no clusterID"
+	case apierrors.IsForbidden(err):
+		reason = "not allowed to read the ClusterVersion"
+	case apierrors.IsNotFound(err):
+		reason = "ClusterVersion not found"
+	default:
+		return clusterIdentity{}, fmt.Errorf("failed to read cluster version: %v", err)
+	}
+	cb.logger.Warn("cluster_id_fallback", "Using the kube-system UID as cluster ID", map[string]interface{}{
+		"reason":     reason,
+		"cluster_id": identity.ID,
+	})
+	cb.recordWarning("ClusterIDFallback", fmt.Sprintf("Using the kube-system UID as cluster ID: %s", reason))
+	return identity, nil
+}
+
+// ensureClusterMarker checks that the prefix of CLUSTER_NAME belongs to this
+// cluster, claiming it if it has no marker yet. A prefix owned by another
+// cluster ID is refused unless FORCE_CLUSTER_CLAIM is set. A marker holding
+// either ID of this cluster is accepted and keeps its recorded ID, so a
+// change of RBAC that switches the ID source does not lock the cluster out.
+func (cb *ClusterBackup) ensureClusterMarker() error {
+	if cb.clusterID != "" {
+		return nil
+	}
+	identity, err := cb.detectClusterID()
+	if err != nil {
+		return fmt.Errorf("failed to determine cluster ID: %v", err)
+	}
+	id, source := identity.ID, identity.Source
+
+	var marker ClusterMarker
+	err = cb.getJSON(cb.clusterMarkerKey(), &marker)
+	switch {
+	case err != nil && minio.ToErrorResponse(err).Code != "NoSuchKey":
+		return fmt.Errorf("failed to read cluster marker: %v", err)
+	case err == nil && identity.matches(marker):
+		cb.clusterID = marker.ClusterID
+		if marker.KubeSystemUID == "" {
+			cb.recordKubeSystemUID(marker, identity)
+		}
+		return nil
+	case err == nil && !cb.config.ForceClusterClaim:
+		return fmt.Errorf("prefix of cluster %s belongs to cluster ID %s, this cluster is %s; set a unique CLUSTER_NAME, or FORCE_CLUSTER_CLAIM=true to take it over",
+			cb.config.ClusterName, marker.ClusterID, id)
+	}
+
+	claim := ClusterMarker{
+		ClusterName:   cb.config.ClusterName,
+		ClusterID:     id,
+		IDSource:      source,
+		KubeSystemUID: identity.KubeSystemUID,
+		ClaimedAt:     time.Now().UTC(),
+	}
+	if err == nil {
+		claim.PreviousClusterID = marker.ClusterID
+		cb.recordWarning("ClusterPrefixClaimed", fmt.Sprintf("Took over the prefix of cluster %s from cluster ID %s", cb.config.ClusterName, marker.ClusterID))
+	}
+	if err := cb.putJSON(cb.clusterMarkerKey(), claim); err != nil {
+		return fmt.Errorf("failed to write cluster marker: %v", err)
+	}
+	cb.logger.Info("cluster_marker_written", "Cluster prefix claimed", map[string]interface{}{
+		"cluster":             cb.config.ClusterName,
+		"cluster_id":          id,
+		"id_source":           source,
+		"previous_cluster_id": claim.PreviousClusterID,
+	})
+	cb.clusterID = id
+	return nil
+}
+
+// recordKubeSystemUID adds the kube-system UID to a marker written before it
+// was recorded, so the marker keeps matching if the ClusterVersion becomes
+// unreadable. A failure only leaves the marker as it was.
+func (cb *ClusterBackup) recordKubeSystemUID(marker ClusterMarker, identity clusterIdentity) {
+	marker.KubeSystemUID = identity.KubeSystemUID
+	if err := cb.putJSON(cb.clusterMarkerKey(), marker); err != nil {
+		cb.logger.Warn("cluster_marker_update_failed", "Failed to record the kube-system UID in the cluster marker", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
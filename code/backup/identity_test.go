@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestClusterIdentityMatches(t *testing.T) {
+	openShift := clusterIdentity{ID: "cv-1", Source: clusterIDSourceClusterVersion, KubeSystemUID: "uid-1"}
+	fallback := clusterIdentity{ID: "uid-1", Source: clusterIDSourceKubeSystem, KubeSystemUID: "uid-1"}
+
+	tests := []struct {
+		name     string
+		identity clusterIdentity
+		marker   ClusterMarker
+		want     bool
+	}{
+		{"same ClusterVersion ID", openShift, ClusterMarker{ClusterID: "cv-1"}, true},
+		{"same kube-system UID", fallback, ClusterMarker{ClusterID: "uid-1"}, true},
+		{"marker from fallback, ClusterVersion now readable", openShift, ClusterMarker{ClusterID: "uid-1"}, true},
+		{"marker from ClusterVersion, now forbidden", fallback, ClusterMarker{ClusterID: "cv-1", KubeSystemUID: "uid-1"}, true},
+		{"old marker from ClusterVersion, now forbidden", fallback, ClusterMarker{ClusterID: "cv-1"}, false},
+		{"other cluster", openShift, ClusterMarker{ClusterID: "cv-2", KubeSystemUID: "uid-2"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.identity.matches(tt.marker); got != tt.want {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+// lifecycleRuleID is the ID of the bucket lifecycle rule owned by this
+// cluster. Rules with other IDs are left untouched.
+func (cb *ClusterBackup) lifecycleRuleID() string {
+	return "clusterbackup-" + cb.config.ClusterName
+}
+
+// ensureBucket checks that the backup bucket exists and creates it when
+// bucket creation is enabled.
+func (cb *ClusterBackup) ensureBucket() error {
+	exists, err := cb.minioClient.BucketExists(cb.ctx, cb.config.MinIOBucket)
+	if err != nil {
+		return fmt.Errorf("failed to check bucket existence: %v", err)
+	}
+	if exists {
+		return nil
+	}
+	if !cb.config.CreateBucket {
+		return fmt.Errorf("bucket %s does not exist", cb.config.MinIOBucket)
+	}
+
+	// Object locking can only be enabled when the bucket is created
+	if err := cb.minioClient.MakeBucket(cb.ctx, cb.config.MinIOBucket, minio.MakeBucketOptions{
+		Region:        cb.config.MinIORegion,
+		ObjectLocking: cb.objectLockRequested(),
+	}); err != nil {
+		return fmt.Errorf("failed to create bucket %s: %v", cb.config.MinIOBucket, err)
+	}
+	cb.logger.Info("minio_bucket_created", "Created missing MinIO bucket", map[string]interface{}{
+		"bucket":         cb.config.MinIOBucket,
+		"region":         cb.config.MinIORegion,
+		"object_locking": cb.objectLockRequested(),
+	})
+	return nil
+}
+
+// bucketVersioned reports whether versioning is enabled or suspended on the
+// backup bucket; in both cases overwritten objects may leave older versions.
+func (cb *ClusterBackup) bucketVersioned() (bool, error) {
+	versioning, err := cb.minioClient.GetBucketVersioning(cb.ctx, cb.config.MinIOBucket)
+	if err != nil {
+		return false, err
+	}
+	return versioning.Enabled() || versioning.Suspended(), nil
+}
+
+// lifecycleUnsupportedReason returns why the retention configuration cannot
+// be expressed as bucket lifecycle rules, or "" when it can.
+func (cb *ClusterBackup) lifecycleUnsupportedReason() (string, error) {
+	if cb.backupConfig.RetentionPolicy == retentionPolicyGFS && cb.backupConfig.SnapshotMode {
+		return "gfs retention keeps snapshots by schedule, not by age", nil
+	}
+	if len(cb.backupConfig.RetentionRules) > 0 {
+		return "retention-rules may extend retention, lifecycle expiration always applies the shortest matching rule", nil
+	}
+	if cb.objectLockRequested() {
+		return "lifecycle expiration hides locked objects behind delete markers instead of skipping them", nil
+	}
+	pins, err := cb.pinnedRuns()
+	if err != nil {
+		return "", fmt.Errorf("failed to read pinned runs: %v", err)
+	}
+	if len(pins) > 0 {
+		return fmt.Sprintf("%d pinned runs exist, lifecycle expiration would delete them", len(pins)), nil
+	}
+	return "", nil
+}
+
+// desiredLifecycleRules builds the lifecycle rules implementing age-based
+// retention for this cluster's prefix.
+func (cb *ClusterBackup) desiredLifecycleRules(versioned bool) []lifecycle.Rule {
+	expire := lifecycle.Rule{
+		ID:         cb.lifecycleRuleID(),
+		Status:     "Enabled",
+		RuleFilter: lifecycle.Filter{Prefix: cb.clusterPrefix()},
+		Expiration: lifecycle.Expiration{Days: lifecycle.ExpirationDays(cb.backupConfig.RetentionDays)},
+	}
+	if !versioned {
+		return []lifecycle.Rule{expire}
+	}
+
+	noncurrentDays := cb.backupConfig.NoncurrentVersionDays
+	if noncurrentDays <= 0 {
+		noncurrentDays = cb.backupConfig.RetentionDays
+	}
+	expire.NoncurrentVersionExpiration = lifecycle.NoncurrentVersionExpiration{
+		NoncurrentDays: lifecycle.ExpirationDays(noncurrentDays),
+	}
+
+	// Expiring a current version in a versioned bucket only adds a delete
+	// marker; this rule removes the markers once no versions remain
+	markers := lifecycle.Rule{
+		ID:         cb.lifecycleRuleID() + "-delete-markers",
+		Status:     "Enabled",
+		RuleFilter: lifecycle.Filter{Prefix: cb.clusterPrefix()},
+		Expiration: lifecycle.Expiration{DeleteMarker: true},
+	}
+	return []lifecycle.Rule{expire, markers}
+}
+
+// getBucketLifecycle returns the bucket lifecycle configuration, empty when
+// none is set.
+func (cb *ClusterBackup) getBucketLifecycle() (*lifecycle.Configuration, error) {
+	config, err := cb.minioClient.GetBucketLifecycle(cb.ctx, cb.config.MinIOBucket)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchLifecycleConfiguration" {
+			return lifecycle.NewConfiguration(), nil
+		}
+		return nil, err
+	}
+	return config, nil
+}
+
+// isOwnedLifecycleRule reports whether a bucket lifecycle rule was written
+// for this cluster.
+func (cb *ClusterBackup) isOwnedLifecycleRule(rule lifecycle.Rule) bool {
+	return rule.ID == cb.lifecycleRuleID() || rule.ID == cb.lifecycleRuleID()+"-delete-markers"
+}
+
+// ensureLifecycle installs and verifies the lifecycle rules of this cluster
+// once per process. Once they are verified, client-side age-based cleanup is
+// skipped.
+func (cb *ClusterBackup) ensureLifecycle() error {
+	if cb.lifecycleChecked {
+		return nil
+	}
+	if err := cb.applyLifecycle(); err != nil {
+		return err
+	}
+	cb.lifecycleChecked = true
+	return nil
+}
+
+func (cb *ClusterBackup) applyLifecycle() error {
+	if !cb.backupConfig.LifecycleManagement {
+		return nil
+	}
+
+	reason, err := cb.lifecycleUnsupportedReason()
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		cb.logger.Warn("lifecycle_unsupported", "Retention cannot be expressed as lifecycle rules, using client-side cleanup", map[string]interface{}{
+			"reason": reason,
+		})
+		// Rules installed earlier would keep expiring what cleanup protects
+		return cb.removeOwnedLifecycleRules()
+	}
+
+	versioned, err := cb.bucketVersioned()
+	if err != nil {
+		return fmt.Errorf("failed to read bucket versioning: %v", err)
+	}
+	desired := cb.desiredLifecycleRules(versioned)
+
+	current, err := cb.getBucketLifecycle()
+	if err != nil {
+		return fmt.Errorf("failed to read bucket lifecycle: %v", err)
+	}
+
+	var others, owned []lifecycle.Rule
+	for _, rule := range current.Rules {
+		if cb.isOwnedLifecycleRule(rule) {
+			owned = append(owned, rule)
+		} else {
+			others = append(others, rule)
+		}
+	}
+
+	if !lifecycleRulesEqual(owned, desired) {
+		if cb.backupConfig.CleanupDryRun {
+			cb.logger.Info("lifecycle_dry_run", "Lifecycle rules differ, dry run leaves the bucket unchanged", map[string]interface{}{
+				"current": owned,
+				"desired": desired,
+			})
+			return nil
+		}
+
+		updated := lifecycle.NewConfiguration()
+		updated.Rules = append(others, desired...)
+		if err := cb.minioClient.SetBucketLifecycle(cb.ctx, cb.config.MinIOBucket, updated); err != nil {
+			return fmt.Errorf("failed to set bucket lifecycle: %v", err)
+		}
+		cb.logger.Info("lifecycle_updated", "Bucket lifecycle rules updated", map[string]interface{}{
+			"bucket": cb.config.MinIOBucket,
+			"rules":  desired,
+		})
+
+		// Read back to make sure the server kept the rules as written
+		current, err = cb.getBucketLifecycle()
+		if err != nil {
+			return fmt.Errorf("failed to read bucket lifecycle: %v", err)
+		}
+		owned = owned[:0]
+		for _, rule := range current.Rules {
+			if cb.isOwnedLifecycleRule(rule) {
+				owned = append(owned, rule)
+			}
+		}
+		if !lifecycleRulesEqual(owned, desired) {
+			return fmt.Errorf("bucket lifecycle rules for %s were not applied as configured", cb.clusterPrefix())
+		}
+	}
+
+	cb.lifecycleActive = true
+	cb.logger.Info("lifecycle_verified", "Bucket lifecycle rules verified, client-side cleanup disabled", map[string]interface{}{
+		"bucket":         cb.config.MinIOBucket,
+		"prefix":         cb.clusterPrefix(),
+		"retention_days": cb.backupConfig.RetentionDays,
+		"versioned":      versioned,
+	})
+	return nil
+}
+
+// removeOwnedLifecycleRules removes the lifecycle rules of this cluster,
+// keeping the rules of other clusters and other tools.
+func (cb *ClusterBackup) removeOwnedLifecycleRules() error {
+	current, err := cb.getBucketLifecycle()
+	if err != nil {
+		return fmt.Errorf("failed to read bucket lifecycle: %v", err)
+	}
+	var others []lifecycle.Rule
+	for _, rule := range current.Rules {
+		if !cb.isOwnedLifecycleRule(rule) {
+			others = append(others, rule)
+		}
+	}
+	if len(others) == len(current.Rules) {
+		return nil
+	}
+	if cb.backupConfig.CleanupDryRun {
+		cb.logger.Info("lifecycle_dry_run", "Lifecycle rules of this cluster would be removed, dry run leaves the bucket unchanged", map[string]interface{}{
+			"prefix": cb.clusterPrefix(),
+		})
+		return nil
+	}
+
+	updated := lifecycle.NewConfiguration()
+	updated.Rules = others
+	if err := cb.minioClient.SetBucketLifecycle(cb.ctx, cb.config.MinIOBucket, updated); err != nil {
+		return fmt.Errorf("failed to remove bucket lifecycle rules: %v", err)
+	}
+	cb.logger.Info("lifecycle_removed", "Bucket lifecycle rules of this cluster removed, client-side cleanup takes over", map[string]interface{}{
+		"bucket": cb.config.MinIOBucket,
+		"prefix": cb.clusterPrefix(),
+	})
+	return nil
+}
+
+// lifecycleRulesEqual compares rules on the fields this tool sets, ignoring
+// order and server-side normalization of unrelated fields.
+func lifecycleRulesEqual(a, b []lifecycle.Rule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	key := func(rule lifecycle.Rule) string {
+		return fmt.Sprintf("%s|%s|%s|%d|%t|%d", rule.ID, rule.Status, rule.RuleFilter.Prefix,
+			rule.Expiration.Days, rule.Expiration.DeleteMarker.IsEnabled(), rule.NoncurrentVersionExpiration.NoncurrentDays)
+	}
+	counts := make(map[string]int)
+	for _, rule := range a {
+		counts[key(rule)]++
+	}
+	for _, rule := range b {
+		counts[key(rule)]--
+	}
+	for _, count := range counts {
+		if count != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -4,20 +4,23 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"gopkg.in/yaml.v3"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -25,16 +28,27 @@ import (
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+
+	"shared"
 )
 
 type Config struct {
 	ClusterDomain     string
 	ClusterName       string
-	MinIOEndpoint     string
-	MinIOAccessKey    string
-	MinIOSecretKey    string
+	// Object storage connection, credentials and encryption
+	shared.StorageConfig
 	MinIOBucket       string
-	MinIOUseSSL       bool
+	CreateBucket      bool
+	// Notification webhooks
+	NotifyWebhookURL      string
+	NotifySlackWebhookURL string
+	NotifyTeamsWebhookURL string
+	NotifyMode            string
+	NotifyTemplate        string
+	RunLabel          string
+	PinRun            bool
+	// Take over a prefix whose cluster marker names another cluster ID
+	ForceClusterClaim bool
 	BatchSize         int
 	RetryAttempts     int
 	RetryDelay        time.Duration
@@ -48,9 +62,13 @@ type BackupConfig struct {
 	FilteringMode           string   // "whitelist", "blacklist", "hybrid"
 	IncludeResources        []string
 	ExcludeResources        []string
-	IncludeNamespaces       []string
+	IncludeNamespaces       []string // names, globs, /regex/, !pattern
 	ExcludeNamespaces       []string
-	IncludeCRDs             []string
+	NamespaceSelector       string
+	RequireNamespaceOptIn   bool
+	IncludeCRDs             []string // <resource>.<group> globs
+	IncludeAllCRDs          bool
+	ExcludeCRDs             []string
 	LabelSelector           string
 	AnnotationSelector      string
 	MaxResourceSize         string
@@ -59,29 +77,80 @@ type BackupConfig struct {
 	IncludeStatus           bool
 	OpenShiftMode           string
 	IncludeOpenShiftRes     bool
+	StripOpenShiftFields    bool
 	ValidateYAML            bool
 	SkipInvalidResources    bool
+	VerifyUploads           bool
 	// Cleanup configuration
 	EnableCleanup           bool
 	RetentionDays           int
 	CleanupOnStartup        bool
+	CleanupDryRun           bool
+	CleanupConcurrency      int
+	RetentionRules          []RetentionRule
+	RetentionPolicy         string // "age", "gfs"
+	GFS                     GFSPolicy
+	SnapshotMode            bool
+	// Bucket lifecycle (ILM) configuration
+	LifecycleManagement     bool
+	NoncurrentVersionDays   int
+	// Object lock (WORM) configuration
+	ObjectLockMode          string // "", "governance", "compliance"
+	ObjectLockRetentionDays int
+	ObjectLockLegalHold     bool
+	// Status reporting configuration
+	StatusConfigMap         string
+	PublishEvents           bool
+	// Helm release summaries
+	HelmReleases            bool
+	// Back up objects referenced by selected objects
+	IncludeDependencies     bool
+	// Handling of objects with a controller owner
+	OwnedResources          string // "skip", "include", "uncaptured-owners"
+	// CRDs of backed-up custom resource types
+	BackupCRDDefinitions    bool
+	StripCRDCABundle        bool
+	// Namespace objects, quotas and limit ranges stored with each namespace
+	NamespaceMetadata       bool
 }
 
 type ClusterBackup struct {
 	config       *Config
 	backupConfig *BackupConfig
 	minioClient  *minio.Client
+	sse          encrypt.ServerSide
 	kubeClient   kubernetes.Interface
 	dynamicClient dynamic.Interface
 	discoveryClient discovery.DiscoveryInterface
 	metrics      *BackupMetrics
 	ctx          context.Context
 	logger       *StructuredLogger
+	runID        string
+	lifecycleChecked bool
+	lifecycleActive  bool
+	manifestMu   sync.Mutex
+	manifest     []ManifestEntry
+	manifestIndex map[string]int
+	notifier     *shared.Notifier
+	runStats     runStats
+	cleanupStats cleanupStats
+	podRef       *corev1.ObjectReference
+	clusterID    string
+	helmResources map[string][]helmResourceRef
+	dependencyQueue []dependencyRef
+	capturedKinds map[string]bool
+	crdIndex     map[string]*unstructured.Unstructured
+	namespaceAnnotations map[string]map[string]string
+	selectedNamespaces map[string]bool
+	sccBindingList *unstructured.UnstructuredList
+	crdRecords   []CRDRecord
+	warningsSeen map[string]bool
 }
 
 type StructuredLogger struct {
 	clusterName string
 	logLevel    string
+	output      io.Writer
 }
 
 type LogEntry struct {
@@ -104,6 +173,8 @@ type BackupMetrics struct {
 	ResourcesBackedUp prometheus.Counter
 	LastBackupTime    prometheus.Gauge
 	NamespacesBackedUp prometheus.Gauge
+	VerifyFailures    prometheus.Counter
+	DriftObjects      *prometheus.GaugeVec
 }
 
 var (
@@ -125,6 +196,10 @@ var (
 
 func main() {
 	logger := NewStructuredLogger("backup", getSecretValue("CLUSTER_NAME", "default"))
+	// Commands print their reports on stdout, so logs go to stderr
+	if len(os.Args) > 1 {
+		logger.output = os.Stderr
+	}
 	logger.Info("startup", "Starting Enhanced OpenShift Cluster Backup...", nil)
 
 	// Check if it's a health check request
@@ -148,6 +223,17 @@ func main() {
 		logger.Fatal("backup_client_init", "Failed to create backup client", map[string]interface{}{"error": err.Error()})
 	}
 
+	// Administrative commands run instead of a backup
+	if len(os.Args) > 1 {
+		if err := runCommand(backup, os.Args[1:]); err != nil {
+			logger.Fatal("command_failed", "Command failed", map[string]interface{}{
+				"command": os.Args[1],
+				"error": err.Error(),
+			})
+		}
+		return
+	}
+
 	logger.Info("config_loaded", "Configuration loaded successfully", map[string]interface{}{
 		"cluster_name": config.ClusterName,
 		"filtering_mode": backupConfig.FilteringMode,
@@ -161,21 +247,32 @@ func main() {
 	// Perform cleanup on startup if configured
 	if backup.shouldCleanupOnStartup() {
 		logger.Info("cleanup_startup", "Performing cleanup on startup", nil)
-		if err := backup.performCleanup(); err != nil {
+		cleanupStart := time.Now()
+		err := backup.performCleanup()
+		if err != nil {
 			logger.Error("cleanup_startup_failed", "Startup cleanup failed", map[string]interface{}{"error": err.Error()})
 		}
+		backup.notifyCleanup(cleanupStart, err)
+		backup.publishCleanupStatus(cleanupStart, err)
 	}
 
-	if err := backup.Run(); err != nil {
+	err = backup.Run()
+	backup.notifyBackup(err)
+	backup.publishRunStatus(err)
+	if err != nil {
 		logger.Fatal("backup_run", "Backup failed", map[string]interface{}{"error": err.Error()})
 	}
 
 	// Perform cleanup after backup if configured
 	if backup.backupConfig.EnableCleanup && !backup.backupConfig.CleanupOnStartup {
 		logger.Info("cleanup_post_backup", "Performing cleanup after backup", nil)
-		if err := backup.performCleanup(); err != nil {
+		cleanupStart := time.Now()
+		err := backup.performCleanup()
+		if err != nil {
 			logger.Error("cleanup_post_backup_failed", "Post-backup cleanup failed", map[string]interface{}{"error": err.Error()})
 		}
+		backup.notifyCleanup(cleanupStart, err)
+		backup.publishCleanupStatus(cleanupStart, err)
 	}
 
 	logger.Info("backup_complete", "Backup completed successfully", nil)
@@ -185,11 +282,38 @@ func loadConfig() (*Config, error) {
 	config := &Config{
 		ClusterDomain:     getSecretValue("CLUSTER_DOMAIN", "cluster.local"),
 		ClusterName:       getSecretValue("CLUSTER_NAME", "default"),
-		MinIOEndpoint:     getSecretValue("MINIO_ENDPOINT", ""),
-		MinIOAccessKey:    getSecretValue("MINIO_ACCESS_KEY", ""),
-		MinIOSecretKey:    getSecretValue("MINIO_SECRET_KEY", ""),
+		StorageConfig: shared.StorageConfig{
+			MinIOEndpoint:                 getSecretValue("MINIO_ENDPOINT", ""),
+			MinIOAccessKey:                getSecretValue("MINIO_ACCESS_KEY", ""),
+			MinIOSecretKey:                getSecretValue("MINIO_SECRET_KEY", ""),
+			MinIOUseSSL:                   getSecretValue("MINIO_USE_SSL", "true") == "true",
+			MinIORegion:                   getSecretValue("MINIO_REGION", ""),
+			MinIOBucketLookup:             getSecretValue("MINIO_BUCKET_LOOKUP", "auto"),
+			MinIOCredentialSources:        parseCommaSeparated(getSecretValue("MINIO_CREDENTIAL_SOURCES", "static")),
+			MinIOAccessKeyFile:            getSecretValue("MINIO_ACCESS_KEY_FILE", ""),
+			MinIOSecretKeyFile:            getSecretValue("MINIO_SECRET_KEY_FILE", ""),
+			MinIOSharedCredentialsFile:    getSecretValue("MINIO_SHARED_CREDENTIALS_FILE", ""),
+			MinIOSharedCredentialsProfile: getSecretValue("MINIO_SHARED_CREDENTIALS_PROFILE", ""),
+			MinIOSTSEndpoint:              getSecretValue("MINIO_STS_ENDPOINT", ""),
+			MinIOWebIdentityTokenFile:     getSecretValue("MINIO_WEB_IDENTITY_TOKEN_FILE", ""),
+			MinIORoleARN:                  getSecretValue("MINIO_ROLE_ARN", ""),
+			MinIOCABundle:                 getSecretValue("MINIO_CA_BUNDLE", ""),
+			MinIOClientCert:               getSecretValue("MINIO_CLIENT_CERT", ""),
+			MinIOClientKey:                getSecretValue("MINIO_CLIENT_KEY", ""),
+			MinIOSSEType:                  getSecretValue("MINIO_SSE_TYPE", shared.SSETypeNone),
+			MinIOSSEKMSKeyID:              getSecretValue("MINIO_SSE_KMS_KEY_ID", ""),
+			MinIOSSECKeyFile:              getSecretValue("MINIO_SSE_C_KEY_FILE", ""),
+		},
 		MinIOBucket:       getSecretValue("MINIO_BUCKET", "cluster-backups"),
-		MinIOUseSSL:       getSecretValue("MINIO_USE_SSL", "true") == "true",
+		CreateBucket:      getSecretValue("MINIO_CREATE_BUCKET", "false") == "true",
+		NotifyWebhookURL:      getSecretValue("NOTIFY_WEBHOOK_URL", ""),
+		NotifySlackWebhookURL: getSecretValue("NOTIFY_SLACK_WEBHOOK_URL", ""),
+		NotifyTeamsWebhookURL: getSecretValue("NOTIFY_TEAMS_WEBHOOK_URL", ""),
+		NotifyMode:            getSecretValue("NOTIFY_MODE", shared.NotifyModeAlways),
+		NotifyTemplate:        getSecretValue("NOTIFY_TEMPLATE", ""),
+		RunLabel:          getSecretValue("BACKUP_RUN_LABEL", ""),
+		PinRun:            getSecretValue("BACKUP_PIN_RUN", "false") == "true",
+		ForceClusterClaim: getSecretValue("FORCE_CLUSTER_CLAIM", "false") == "true",
 		BatchSize:         50,
 		RetryAttempts:     3,
 		RetryDelay:        5 * time.Second,
@@ -227,7 +351,13 @@ func loadConfig() (*Config, error) {
 		}
 	}
 
-	if config.MinIOEndpoint == "" || config.MinIOAccessKey == "" || config.MinIOSecretKey == "" {
+	if config.MinIOEndpoint == "" {
+		return nil, fmt.Errorf("MinIO configuration is incomplete")
+	}
+
+	// Static keys are only mandatory when they are the sole credential source
+	if len(config.MinIOCredentialSources) == 1 && config.MinIOCredentialSources[0] == shared.CredentialSourceStatic &&
+		(config.MinIOAccessKey == "" || config.MinIOSecretKey == "") {
 		return nil, fmt.Errorf("MinIO configuration is incomplete")
 	}
 
@@ -246,12 +376,7 @@ func loadBackupConfig() (*BackupConfig, error) {
 	}
 
 	// Read backup configuration from ConfigMap
-	namespace := os.Getenv("POD_NAMESPACE")
-	if namespace == "" {
-		namespace = "default"
-	}
-
-	configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.TODO(), "backup-config", metav1.GetOptions{})
+	configMap, err := clientset.CoreV1().ConfigMaps(podNamespace()).Get(context.TODO(), "backup-config", metav1.GetOptions{})
 	if err != nil {
 		log.Printf("Warning: Could not load backup-config ConfigMap: %v, using defaults", err)
 		return getDefaultBackupConfig(), nil
@@ -278,9 +403,27 @@ func parseBackupConfig(cm *corev1.ConfigMap) *BackupConfig {
 	if val, ok := cm.Data["exclude-namespaces"]; ok && val != "" {
 		config.ExcludeNamespaces = parseCommaSeparated(val)
 	}
+	if val, ok := cm.Data["namespace-selector"]; ok {
+		config.NamespaceSelector = strings.TrimSpace(val)
+	}
+	if val, ok := cm.Data["require-namespace-opt-in"]; ok {
+		config.RequireNamespaceOptIn = val == "true"
+	}
 	if val, ok := cm.Data["include-crds"]; ok && val != "" {
 		config.IncludeCRDs = parseCommaSeparated(val)
 	}
+	if val, ok := cm.Data["include-all-crds"]; ok {
+		config.IncludeAllCRDs = val == "true"
+	}
+	if val, ok := cm.Data["exclude-crds"]; ok && val != "" {
+		config.ExcludeCRDs = parseCommaSeparated(val)
+	}
+	if val, ok := cm.Data["backup-crd-definitions"]; ok {
+		config.BackupCRDDefinitions = val == "true"
+	}
+	if val, ok := cm.Data["strip-crd-ca-bundle"]; ok {
+		config.StripCRDCABundle = val == "true"
+	}
 	if val, ok := cm.Data["label-selector"]; ok {
 		config.LabelSelector = val
 	}
@@ -292,6 +435,17 @@ func parseBackupConfig(cm *corev1.ConfigMap) *BackupConfig {
 	}
 	if val, ok := cm.Data["follow-owner-references"]; ok {
 		config.FollowOwnerReferences = val == "true"
+		if config.FollowOwnerReferences {
+			config.OwnedResources = ownedResourcesInclude
+		}
+	}
+	if val, ok := cm.Data["owned-resources"]; ok && val != "" {
+		switch mode := strings.ToLower(strings.TrimSpace(val)); mode {
+		case ownedResourcesSkip, ownedResourcesInclude, ownedResourcesUncaptured:
+			config.OwnedResources = mode
+		default:
+			log.Printf("Warning: ignoring owned-resources %q (expected skip, include or uncaptured-owners)", val)
+		}
 	}
 	if val, ok := cm.Data["include-managed-fields"]; ok {
 		config.IncludeManagedFields = val == "true"
@@ -305,12 +459,27 @@ func parseBackupConfig(cm *corev1.ConfigMap) *BackupConfig {
 	if val, ok := cm.Data["include-openshift-resources"]; ok {
 		config.IncludeOpenShiftRes = val == "true"
 	}
+	if val, ok := cm.Data["strip-openshift-generated-fields"]; ok {
+		config.StripOpenShiftFields = val == "true"
+	}
 	if val, ok := cm.Data["validate-yaml"]; ok {
 		config.ValidateYAML = val == "true"
 	}
 	if val, ok := cm.Data["skip-invalid-resources"]; ok {
 		config.SkipInvalidResources = val == "true"
 	}
+	if val, ok := cm.Data["verify-uploads"]; ok {
+		config.VerifyUploads = val == "true"
+	}
+	if val, ok := cm.Data["include-dependencies"]; ok {
+		config.IncludeDependencies = val == "true"
+	}
+	if val, ok := cm.Data["helm-releases"]; ok {
+		config.HelmReleases = val == "true"
+	}
+	if val, ok := cm.Data["namespace-metadata"]; ok {
+		config.NamespaceMetadata = val == "true"
+	}
 	// Cleanup configuration from ConfigMap
 	if val, ok := cm.Data["enable-cleanup"]; ok {
 		config.EnableCleanup = val == "true"
@@ -323,6 +492,69 @@ func parseBackupConfig(cm *corev1.ConfigMap) *BackupConfig {
 	if val, ok := cm.Data["cleanup-on-startup"]; ok {
 		config.CleanupOnStartup = val == "true"
 	}
+	if val, ok := cm.Data["retention-rules"]; ok && strings.TrimSpace(val) != "" {
+		rules, err := parseRetentionRules(val)
+		if err != nil {
+			log.Printf("Warning: ignoring retention-rules: %v", err)
+		} else {
+			config.RetentionRules = rules
+		}
+	}
+	if val, ok := cm.Data["cleanup-dry-run"]; ok {
+		config.CleanupDryRun = val == "true"
+	}
+	if val, ok := cm.Data["cleanup-concurrency"]; ok {
+		if workers, err := strconv.Atoi(strings.TrimSpace(val)); err == nil && workers > 0 {
+			config.CleanupConcurrency = workers
+		}
+	}
+	if val, ok := cm.Data["retention-policy"]; ok && val != "" {
+		config.RetentionPolicy = strings.ToLower(strings.TrimSpace(val))
+	}
+	if val, ok := cm.Data["snapshot-mode"]; ok {
+		config.SnapshotMode = val == "true"
+	}
+	for key, target := range map[string]*int{
+		"gfs-keep-last":    &config.GFS.KeepLast,
+		"gfs-keep-daily":   &config.GFS.KeepDaily,
+		"gfs-keep-weekly":  &config.GFS.KeepWeekly,
+		"gfs-keep-monthly": &config.GFS.KeepMonthly,
+		"gfs-keep-yearly":  &config.GFS.KeepYearly,
+	} {
+		if val, ok := cm.Data[key]; ok {
+			if count, err := strconv.Atoi(strings.TrimSpace(val)); err == nil && count >= 0 {
+				*target = count
+			}
+		}
+	}
+	// Bucket lifecycle configuration from ConfigMap
+	if val, ok := cm.Data["lifecycle-management"]; ok {
+		config.LifecycleManagement = val == "true"
+	}
+	if val, ok := cm.Data["noncurrent-version-days"]; ok {
+		if days, err := strconv.Atoi(strings.TrimSpace(val)); err == nil && days > 0 {
+			config.NoncurrentVersionDays = days
+		}
+	}
+	// Object lock configuration from ConfigMap
+	if val, ok := cm.Data["object-lock-mode"]; ok {
+		config.ObjectLockMode = strings.ToLower(strings.TrimSpace(val))
+	}
+	if val, ok := cm.Data["object-lock-retention-days"]; ok {
+		if days, err := strconv.Atoi(strings.TrimSpace(val)); err == nil && days > 0 {
+			config.ObjectLockRetentionDays = days
+		}
+	}
+	if val, ok := cm.Data["object-lock-legal-hold"]; ok {
+		config.ObjectLockLegalHold = val == "true"
+	}
+	// Status reporting configuration from ConfigMap
+	if val, ok := cm.Data["status-configmap"]; ok {
+		config.StatusConfigMap = strings.TrimSpace(val)
+	}
+	if val, ok := cm.Data["publish-events"]; ok {
+		config.PublishEvents = val == "true"
+	}
 
 	return config
 }
@@ -350,12 +582,28 @@ func getDefaultBackupConfig() *BackupConfig {
 		ValidateYAML:          true,
 		SkipInvalidResources:  true,
 		FollowOwnerReferences: false,
+		OwnedResources:        ownedResourcesSkip,
+		BackupCRDDefinitions:  true,
+		StripCRDCABundle:      true,
 		IncludeManagedFields:  false,
 		IncludeStatus:         false,
 		// Cleanup configuration defaults
 		EnableCleanup:         true,
 		RetentionDays:         7,
 		CleanupOnStartup:      false,
+		CleanupConcurrency:    4,
+		RetentionPolicy:       retentionPolicyAge,
+		GFS: GFSPolicy{
+			KeepLast:    3,
+			KeepDaily:   7,
+			KeepWeekly:  4,
+			KeepMonthly: 6,
+			KeepYearly:  1,
+		},
+		StatusConfigMap:       defaultStatusConfigMap,
+		HelmReleases:          true,
+		NamespaceMetadata:     true,
+		PublishEvents:         true,
 	}
 }
 
@@ -397,14 +645,22 @@ func NewClusterBackup(config *Config, backupConfig *BackupConfig, logger *Struct
 		return nil, fmt.Errorf("failed to create discovery client: %v", err)
 	}
 
-	minioClient, err := minio.New(config.MinIOEndpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(config.MinIOAccessKey, config.MinIOSecretKey, ""),
-		Secure: config.MinIOUseSSL,
-	})
+	minioClient, err := shared.NewMinIOClient(&config.StorageConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create MinIO client: %v", err)
 	}
 
+	sse, err := shared.NewServerSideEncryption(&config.StorageConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure server-side encryption: %v", err)
+	}
+
+	notifier, err := shared.NewNotifier(config.NotifyWebhookURL, config.NotifySlackWebhookURL, config.NotifyTeamsWebhookURL,
+		config.NotifyMode, config.NotifyTemplate, config.RetryAttempts, config.RetryDelay)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure notifications: %v", err)
+	}
+
 	metrics := &BackupMetrics{
 		BackupDuration: promauto.NewHistogram(prometheus.HistogramOpts{
 			Name: "cluster_backup_duration_seconds",
@@ -426,23 +682,35 @@ func NewClusterBackup(config *Config, backupConfig *BackupConfig, logger *Struct
 			Name: "cluster_backup_namespaces_total",
 			Help: "Number of namespaces backed up",
 		}),
+		VerifyFailures: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "cluster_backup_upload_verification_failures_total",
+			Help: "Total number of uploads that failed read-after-write verification",
+		}),
+		DriftObjects: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cluster_backup_drift_objects",
+			Help: "Objects that differ between the live cluster and the last backup",
+		}, []string{"namespace", "change"}),
 	}
 
 	return &ClusterBackup{
 		config:          config,
 		backupConfig:    backupConfig,
 		minioClient:     minioClient,
+		sse:             sse,
 		kubeClient:      kubeClient,
 		dynamicClient:   dynamicClient,
 		discoveryClient: discoveryClient,
 		metrics:         metrics,
 		ctx:             context.Background(),
 		logger:          logger,
+		runID:           newRunID(time.Now()),
+		notifier:        notifier,
 	}, nil
 }
 
 func (cb *ClusterBackup) Run() error {
 	startTime := time.Now()
+	cb.runStats = runStats{StartedAt: startTime, Failures: make(map[string]string)}
 	defer func() {
 		duration := time.Since(startTime)
 		cb.metrics.BackupDuration.Observe(duration.Seconds())
@@ -454,6 +722,7 @@ func (cb *ClusterBackup) Run() error {
 
 	cb.logger.Info("backup_start", "Starting backup operation", map[string]interface{}{
 		"cluster": cb.config.ClusterName + "." + cb.config.ClusterDomain,
+		"run_id": cb.runID,
 		"openshift_mode": cb.backupConfig.OpenShiftMode,
 		"filtering_mode": cb.backupConfig.FilteringMode,
 	})
@@ -472,25 +741,53 @@ func (cb *ClusterBackup) Run() error {
 		"endpoint": cb.config.MinIOEndpoint,
 	})
 
-	exists, err := cb.minioClient.BucketExists(cb.ctx, cb.config.MinIOBucket)
-	if err != nil {
+	if err := cb.ensureBucket(); err != nil {
+		cb.metrics.BackupErrors.Inc()
+		cb.logger.Error("minio_bucket_check", "MinIO bucket is not available", map[string]interface{}{
+			"bucket": cb.config.MinIOBucket,
+			"error": err.Error(),
+		})
+		return err
+	}
+
+	// Refuse to write into the prefix of another cluster with the same name
+	if err := cb.ensureClusterMarker(); err != nil {
 		cb.metrics.BackupErrors.Inc()
-		cb.logger.Error("minio_bucket_check", "Failed to check bucket existence", map[string]interface{}{
+		cb.logger.Error("cluster_marker_check_failed", "Cluster prefix check failed", map[string]interface{}{
+			"cluster": cb.config.ClusterName,
+			"error": err.Error(),
+		})
+		return err
+	}
+
+	if err := cb.verifyObjectLock(); err != nil {
+		cb.metrics.BackupErrors.Inc()
+		cb.logger.Error("object_lock_check_failed", "Object lock verification failed", map[string]interface{}{
 			"bucket": cb.config.MinIOBucket,
 			"error": err.Error(),
 		})
-		return fmt.Errorf("failed to check bucket existence: %v", err)
+		return err
 	}
-	if !exists {
+
+	if err := cb.ensureLifecycle(); err != nil {
 		cb.metrics.BackupErrors.Inc()
-		cb.logger.Error("minio_bucket_missing", "MinIO bucket does not exist", map[string]interface{}{
+		cb.logger.Error("lifecycle_check_failed", "Bucket lifecycle verification failed, using client-side cleanup", map[string]interface{}{
+			"bucket": cb.config.MinIOBucket,
+			"error": err.Error(),
+		})
+	}
+
+	versioned, err := cb.bucketVersioned()
+	if err != nil {
+		cb.logger.Warn("minio_versioning_check", "Failed to read bucket versioning", map[string]interface{}{
 			"bucket": cb.config.MinIOBucket,
+			"error": err.Error(),
 		})
-		return fmt.Errorf("bucket %s does not exist", cb.config.MinIOBucket)
 	}
 
 	cb.logger.Info("minio_ready", "MinIO bucket verified successfully", map[string]interface{}{
 		"bucket": cb.config.MinIOBucket,
+		"versioned": versioned,
 	})
 
 	// Get all available API resources
@@ -508,6 +805,20 @@ func (cb *ClusterBackup) Run() error {
 		"resource_types_found": len(apiResources),
 	})
 
+	if cb.backupConfig.BackupCRDDefinitions {
+		crds, err := cb.backupCRDs(apiResources)
+		if err != nil {
+			cb.metrics.BackupErrors.Inc()
+			cb.logger.Error("crd_backup_failed", "Failed to store CRDs of custom resource types", map[string]interface{}{
+				"error": err.Error(),
+			})
+			cb.recordWarning("CRDBackupFailed", err.Error())
+		}
+		cb.logger.Info("crd_backup_complete", "CRDs of custom resource types stored", map[string]interface{}{
+			"crds": crds,
+		})
+	}
+
 	// Get namespaces to backup
 	cb.logger.Info("namespace_discovery_start", "Starting namespace discovery", nil)
 	namespaces, err := cb.getNamespacesToBackup()
@@ -524,6 +835,7 @@ func (cb *ClusterBackup) Run() error {
 		"namespace_list": namespaces,
 	})
 	cb.metrics.NamespacesBackedUp.Set(float64(len(namespaces)))
+	cb.runStats.Namespaces = len(namespaces)
 
 	totalResources := 0
 	namespaceResults := make([]map[string]interface{}, 0)
@@ -547,6 +859,8 @@ func (cb *ClusterBackup) Run() error {
 			})
 			cb.metrics.BackupErrors.Inc()
 			nsResult["error"] = err.Error()
+			cb.runStats.Failures[ns] = err.Error()
+			totalResources += count
 		} else {
 			cb.logger.Info("namespace_backup_complete", "Namespace backup completed", map[string]interface{}{
 				"namespace": ns,
@@ -559,11 +873,87 @@ func (cb *ClusterBackup) Run() error {
 		namespaceResults = append(namespaceResults, nsResult)
 	}
 
+	if cb.backupConfig.HelmReleases {
+		releases, err := cb.backupHelmReleases(namespaces)
+		if err != nil {
+			cb.metrics.BackupErrors.Inc()
+			cb.logger.Error("helm_release_backup_failed", "Failed to store Helm release summaries", map[string]interface{}{
+				"error": err.Error(),
+			})
+			cb.recordWarning("HelmReleaseBackupFailed", err.Error())
+		}
+		cb.logger.Info("helm_release_backup_complete", "Helm release summaries stored", map[string]interface{}{
+			"releases": releases,
+		})
+	}
+
 	cb.logger.Info("backup_summary", "Backup operation summary", map[string]interface{}{
 		"total_resources": totalResources,
 		"total_namespaces": len(namespaces),
 		"namespace_details": namespaceResults,
 	})
+
+	cb.runStats.Resources = totalResources
+	previous, err := cb.previousManifest()
+	if err != nil {
+		cb.logger.Warn("run_manifest_previous", "Failed to read previous run manifest", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	cb.runStats.Changed = cb.manifestChanged(previous)
+
+	if err := cb.writeManifest(); err != nil {
+		cb.metrics.BackupErrors.Inc()
+		cb.logger.Error("run_manifest_failed", "Failed to write run manifest", map[string]interface{}{
+			"run_id": cb.runID,
+			"error": err.Error(),
+		})
+	}
+
+	record := RunRecord{
+		RunID:        cb.runID,
+		Cluster:      cb.config.ClusterName,
+		Label:        cb.config.RunLabel,
+		StartedAt:    startTime.UTC(),
+		FinishedAt:   time.Now().UTC(),
+		SnapshotMode: cb.backupConfig.SnapshotMode,
+		Namespaces:   len(namespaces),
+		Resources:    totalResources,
+		Result:       runResultSuccess,
+		FailedNamespaces: len(cb.runStats.Failures),
+		ResourceErrors:   cb.runStats.ResourceErrors,
+	}
+	// A run where every namespace failed stored nothing usable
+	allFailed := len(namespaces) > 0 && len(cb.runStats.Failures) == len(namespaces)
+	switch {
+	case allFailed:
+		record.Result = runResultFailure
+	case len(cb.runStats.Failures) > 0:
+		record.Result = runResultPartial
+	}
+	if err := cb.writeRunRecord(record); err != nil {
+		cb.metrics.BackupErrors.Inc()
+		cb.logger.Error("run_record_failed", "Failed to write run record", map[string]interface{}{
+			"run_id": cb.runID,
+			"error": err.Error(),
+		})
+	} else if cb.config.PinRun && !allFailed {
+		if _, err := cb.pinRun(cb.runID); err != nil {
+			cb.metrics.BackupErrors.Inc()
+			cb.logger.Error("run_pin_failed", "Failed to pin run", map[string]interface{}{
+				"run_id": cb.runID,
+				"error": err.Error(),
+			})
+		} else {
+			cb.logger.Info("snapshot_pinned", "Run pinned, it will be skipped by retention cleanup", map[string]interface{}{
+				"run_id": cb.runID,
+				"label": cb.config.RunLabel,
+			})
+		}
+	}
+	if allFailed {
+		return fmt.Errorf("all %d namespaces failed", len(namespaces))
+	}
 	cb.metrics.LastBackupTime.SetToCurrentTime()
 	return nil
 }
@@ -605,6 +995,9 @@ func (cb *ClusterBackup) detectOpenShift() string {
 
 func (cb *ClusterBackup) getAPIResources() ([]metav1.APIResource, error) {
 	var allResources []metav1.APIResource
+	cb.capturedKinds = nil
+	cb.crdIndex = nil
+	cb.sccBindingList = nil
 	
 	// Get standard Kubernetes resources
 	resourceLists, err := cb.discoveryClient.ServerPreferredResources()
@@ -612,66 +1005,43 @@ func (cb *ClusterBackup) getAPIResources() ([]metav1.APIResource, error) {
 		log.Printf("Warning: Some API resources may not be available: %v", err)
 	}
 
-	for _, list := range resourceLists {
-		if list == nil {
-			continue
-		}
-		
-		for _, resource := range list.APIResources {
-			if cb.shouldIncludeResource(resource, list.GroupVersion) {
-				allResources = append(allResources, resource)
-			}
-		}
-	}
-
-	// Add CRDs if specified
-	if len(cb.backupConfig.IncludeCRDs) > 0 {
-		crdResources, err := cb.getCRDResources()
-		if err != nil {
-			log.Printf("Warning: Failed to get CRD resources: %v", err)
-		} else {
-			allResources = append(allResources, crdResources...)
-		}
+	// CRDs tell custom resources apart from built-in and aggregated APIs
+	crds, err := cb.customResourceDefinitions()
+	if err != nil {
+		log.Printf("Warning: Failed to list CRDs, include-all-crds has no effect: %v", err)
 	}
 
-	return allResources, nil
-}
-
-func (cb *ClusterBackup) getCRDResources() ([]metav1.APIResource, error) {
-	var resources []metav1.APIResource
-	
-	for _, crd := range cb.backupConfig.IncludeCRDs {
-		parts := strings.Split(crd, ".")
-		if len(parts) < 2 {
+	for _, list := range resourceLists {
+		if list == nil {
 			continue
 		}
-		
-		resourceName := parts[0]
-		group := strings.Join(parts[1:], ".")
-		
-		// Try to find the CRD in available resources
-		resourceLists, err := cb.discoveryClient.ServerPreferredResources()
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
 		if err != nil {
 			continue
 		}
 		
-		for _, list := range resourceLists {
-			if list == nil {
+		for _, resource := range list.APIResources {
+			// Discovery leaves group and version of list entries empty
+			if resource.Group == "" && resource.Version == "" {
+				resource.Group, resource.Version = gv.Group, gv.Version
+			}
+			if !containsVerb(resource.Verbs, "list") || strings.Contains(resource.Name, "/") {
 				continue
 			}
-			
-			if strings.Contains(list.GroupVersion, group) {
-				for _, resource := range list.APIResources {
-					if resource.Name == resourceName {
-						resources = append(resources, resource)
-						log.Printf("Found CRD resource: %s in %s", resourceName, list.GroupVersion)
-					}
-				}
+
+			included := cb.shouldIncludeResource(resource, list.GroupVersion) || cb.inOpenShiftProfile(resource)
+			if resource.Group != "" {
+				_, custom := crds[resource.Name+"."+resource.Group]
+				included = cb.selectCustomResource(resource, custom, included)
+			}
+			if included {
+				allResources = append(allResources, resource)
+				cb.recordCapturedKind(list.GroupVersion, resource.Kind)
 			}
 		}
 	}
-	
-	return resources, nil
+
+	return allResources, nil
 }
 
 func (cb *ClusterBackup) shouldIncludeResource(resource metav1.APIResource, groupVersion string) bool {
@@ -732,34 +1102,39 @@ func (cb *ClusterBackup) isInExcludeList(resourceName, resourceFullName string)
 }
 
 func (cb *ClusterBackup) getNamespacesToBackup() ([]string, error) {
-	// If specific namespaces are included, use those
-	if len(cb.backupConfig.IncludeNamespaces) > 0 {
-		return cb.backupConfig.IncludeNamespaces, nil
+	selection, err := cb.newNamespaceSelection()
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace-selector: %v", err)
 	}
 
-	// Otherwise, get all namespaces and filter
 	namespaces, err := cb.kubeClient.CoreV1().Namespaces().List(cb.ctx, metav1.ListOptions{})
 	if err != nil {
 		return nil, err
 	}
 
 	var result []string
-	for _, ns := range namespaces.Items {
-		if !cb.shouldExcludeNamespace(ns.Name) {
+	existing := make(map[string]bool, len(namespaces.Items))
+	cb.namespaceAnnotations = make(map[string]map[string]string, len(namespaces.Items))
+	cb.selectedNamespaces = make(map[string]bool)
+	for i := range namespaces.Items {
+		ns := &namespaces.Items[i]
+		existing[ns.Name] = true
+		cb.namespaceAnnotations[ns.Name] = ns.Annotations
+		if selection.selected(ns) {
 			result = append(result, ns.Name)
+			cb.selectedNamespaces[ns.Name] = true
 		}
 	}
 
-	return result, nil
-}
-
-func (cb *ClusterBackup) shouldExcludeNamespace(namespace string) bool {
-	for _, excluded := range cb.backupConfig.ExcludeNamespaces {
-		if namespace == excluded {
-			return true
-		}
+	// Explicitly included namespaces that do not exist are likely typos
+	for _, missing := range selection.missingIncludes(existing) {
+		cb.logger.Warn("namespace_not_found", "Included namespace does not exist", map[string]interface{}{
+			"namespace": missing,
+		})
+		cb.recordWarning("NamespaceNotFound", fmt.Sprintf("Included namespace %s does not exist", missing))
 	}
-	return false
+
+	return result, nil
 }
 
 func (cb *ClusterBackup) backupNamespace(namespace string, apiResources []metav1.APIResource) (int, error) {
@@ -769,29 +1144,37 @@ func (cb *ClusterBackup) backupNamespace(namespace string, apiResources []metav1
 	})
 	resourceCount := 0
 	resourceErrors := 0
-
-	for _, resource := range apiResources {
-		gvr := schema.GroupVersionResource{
-			Group:    "",
-			Version:  "v1",
-			Resource: resource.Name,
-		}
-
-		// Parse group and version from API resource
-		if strings.Contains(resource.Kind, ".") {
-			parts := strings.Split(resource.Kind, ".")
-			if len(parts) >= 2 {
-				gvr.Group = strings.Join(parts[1:], ".")
+	attempted := 0
+	var firstError error
+
+	// The Namespace object and its quotas come first, a restore recreates
+	// them before the namespace content
+	if cb.backupConfig.NamespaceMetadata {
+		attempted++
+		count, err := cb.backupNamespaceMetadata(namespace)
+		if err != nil {
+			cb.logger.Error("namespace_metadata_backup_failed", "Error backing up namespace objects", map[string]interface{}{
+				"namespace": namespace,
+				"error": err.Error(),
+			})
+			if apierrors.IsForbidden(err) {
+				cb.recordWarning("NamespaceMetadataForbidden", fmt.Sprintf("Not allowed to read namespace %s objects: %v", namespace, err))
+			}
+			resourceErrors++
+			if firstError == nil {
+				firstError = err
 			}
 		}
+		resourceCount += count
+	}
 
-		if resource.Group != "" {
-			gvr.Group = resource.Group
-		}
-		if resource.Version != "" {
-			gvr.Version = resource.Version
+	for _, resource := range apiResources {
+		gvr := resourceGVR(resource)
+		if cb.skipInResourceLoop(gvr) {
+			continue
 		}
 
+		attempted++
 		resourceStartTime := time.Now()
 		count, err := cb.backupResource(namespace, gvr, resource)
 		resourceDuration := time.Since(resourceStartTime)
@@ -805,7 +1188,15 @@ func (cb *ClusterBackup) backupNamespace(namespace string, apiResources []metav1
 				"error": err.Error(),
 				"duration_ms": float64(resourceDuration.Nanoseconds()) / 1e6,
 			})
+			if apierrors.IsForbidden(err) {
+				cb.recordWarning("ResourceForbidden", fmt.Sprintf("Not allowed to list %s, they are missing from the backup", gvr.GroupResource().String()))
+			}
 			resourceErrors++
+			if firstError == nil {
+				firstError = fmt.Errorf("%s: %w", gvr.GroupResource().String(), err)
+			}
+			// Objects uploaded before the failure are part of the backup
+			resourceCount += count
 			continue
 		}
 		
@@ -821,17 +1212,91 @@ func (cb *ClusterBackup) backupNamespace(namespace string, apiResources []metav1
 		resourceCount += count
 	}
 
+	// SCC grants to the namespace's ServiceAccounts are cluster-scoped
+	if cb.openShiftProfile() {
+		attempted++
+		count, err := cb.backupSCCBindings(namespace)
+		if err != nil {
+			cb.logger.Error("scc_binding_backup_failed", "Error backing up SCC bindings", map[string]interface{}{
+				"namespace": namespace,
+				"error": err.Error(),
+			})
+			resourceErrors++
+			if firstError == nil {
+				firstError = err
+			}
+		}
+		resourceCount += count
+	}
+
+	// References of the selected objects are resolved once the namespace is
+	// complete, so objects the selection included are not fetched again
+	dependencies := 0
+	if cb.backupConfig.IncludeDependencies {
+		attempted++
+		count, err := cb.backupDependencies(namespace)
+		if err != nil {
+			cb.logger.Error("dependency_backup_failed", "Error backing up referenced objects", map[string]interface{}{
+				"namespace": namespace,
+				"error": err.Error(),
+			})
+			resourceErrors++
+			if firstError == nil {
+				firstError = err
+			}
+		}
+		dependencies = count
+		resourceCount += count
+	}
+
 	cb.logger.Info("namespace_backup_summary", "Namespace backup completed", map[string]interface{}{
 		"namespace": namespace,
 		"total_resources": resourceCount,
+		"dependencies_included": dependencies,
 		"resource_errors": resourceErrors,
 		"api_types_processed": len(apiResources),
 	})
-	
+
+	// A namespace fails when nothing could be read from it. Failed resource
+	// types are warnings: the run still holds the rest of the namespace and
+	// stays usable for retention and git-sync
+	if resourceErrors > 0 && resourceErrors == attempted {
+		return resourceCount, fmt.Errorf("%d errors, first: %w", resourceErrors, firstError)
+	}
+	if resourceErrors > 0 {
+		cb.runStats.ResourceErrors += resourceErrors
+		cb.recordWarning("ResourceTypesFailed", fmt.Sprintf("%d resource types of namespace %s failed, first: %v", resourceErrors, namespace, firstError))
+	}
 	return resourceCount, nil
 }
 
-func (cb *ClusterBackup) backupResource(namespace string, gvr schema.GroupVersionResource, resource metav1.APIResource) (int, error) {
+func resourceGVR(resource metav1.APIResource) schema.GroupVersionResource {
+	gvr := schema.GroupVersionResource{
+		Group:    "",
+		Version:  "v1",
+		Resource: resource.Name,
+	}
+
+	// Parse group and version from API resource
+	if strings.Contains(resource.Kind, ".") {
+		parts := strings.Split(resource.Kind, ".")
+		if len(parts) >= 2 {
+			gvr.Group = strings.Join(parts[1:], ".")
+		}
+	}
+
+	if resource.Group != "" {
+		gvr.Group = resource.Group
+	}
+	if resource.Version != "" {
+		gvr.Version = resource.Version
+	}
+	return gvr
+}
+
+// listResourceItems lists the objects of one resource type in a namespace,
+// applying the configured label selector.
+func (cb *ClusterBackup) listResourceItems(namespace string, gvr schema.GroupVersionResource, resource metav1.APIResource) (*unstructured.UnstructuredList, error) {
 	var listOptions metav1.ListOptions
 	
 	if cb.backupConfig.LabelSelector != "" {
@@ -849,7 +1314,6 @@ func (cb *ClusterBackup) backupResource(namespace string, gvr schema.GroupVersio
 
 	var resources *unstructured.UnstructuredList
 	var err error
-
 	if resource.Namespaced {
 		resources, err = cb.dynamicClient.Resource(gvr).Namespace(namespace).List(cb.ctx, listOptions)
 	} else {
@@ -862,7 +1326,16 @@ func (cb *ClusterBackup) backupResource(namespace string, gvr schema.GroupVersio
 			"resource_type": resource.Name,
 			"error": err.Error(),
 		})
-		return 0, fmt.Errorf("failed to list %s: %v", resource.Name, err)
+		return nil, fmt.Errorf("failed to list %s: %w", resource.Name, err)
+	}
+
+	return resources, nil
+}
+
+func (cb *ClusterBackup) backupResource(namespace string, gvr schema.GroupVersionResource, resource metav1.APIResource) (int, error) {
+	resources, err := cb.listResourceItems(namespace, gvr, resource)
+	if err != nil {
+		return 0, err
 	}
 
 	count := 0
@@ -898,6 +1371,7 @@ func (cb *ClusterBackup) backupResource(namespace string, gvr schema.GroupVersio
 						"resource_name": item.GetName(),
 						"validation_error": err.Error(),
 					})
+					cb.recordWarning("InvalidResourceSkipped", fmt.Sprintf("Skipped invalid %s %s/%s: %v", resource.Name, namespace, item.GetName(), err))
 					invalid++
 					continue
 				}
@@ -911,7 +1385,7 @@ func (cb *ClusterBackup) backupResource(namespace string, gvr schema.GroupVersio
 			}
 		}
 
-		if err := cb.uploadResource(namespace, gvr.Resource, item.GetName(), cleaned); err != nil {
+		if err := cb.uploadResource(namespace, gvr, &item, cleaned); err != nil {
 			cb.logger.Error("resource_upload_failed", "Failed to upload resource to MinIO", map[string]interface{}{
 				"namespace": namespace,
 				"resource_type": resource.Name,
@@ -923,12 +1397,14 @@ func (cb *ClusterBackup) backupResource(namespace string, gvr schema.GroupVersio
 
 		count++
 		cb.metrics.ResourcesBackedUp.Inc()
+		cb.trackHelmResource(&item)
+		cb.queueDependencies(&item)
 		
 		cb.logger.Debug("resource_uploaded", "Resource successfully uploaded", map[string]interface{}{
 			"namespace": namespace,
 			"resource_type": resource.Name,
 			"resource_name": item.GetName(),
-			"path": fmt.Sprintf("clusterbackup/%s/%s/%s/%s.yaml", cb.config.ClusterName, namespace, gvr.Resource, item.GetName()),
+			"path": cb.objectKey(namespace, gvr.Resource, item.GetName()),
 		})
 	}
 
@@ -945,6 +1421,16 @@ func (cb *ClusterBackup) backupResource(namespace string, gvr schema.GroupVersio
 }
 
 func (cb *ClusterBackup) shouldSkipResource(resource *unstructured.Unstructured) bool {
+	// Objects and namespaces can opt out with backup.cluster/exclude
+	if cb.excludedByAnnotation(resource) {
+		return true
+	}
+
+	// Secrets OpenShift generates for ServiceAccounts are recreated by it
+	if cb.skipOpenShiftGenerated(resource) {
+		return true
+	}
+
 	// Skip resources with specific annotations if configured
 	if cb.backupConfig.AnnotationSelector != "" {
 		annotations := resource.GetAnnotations()
@@ -963,18 +1449,8 @@ func (cb *ClusterBackup) shouldSkipResource(resource *unstructured.Unstructured)
 		}
 	}
 
-	// Skip resources managed by operators if not following owner references
-	if !cb.backupConfig.FollowOwnerReferences {
-		if owners := resource.GetOwnerReferences(); len(owners) > 0 {
-			for _, owner := range owners {
-				if owner.Controller != nil && *owner.Controller {
-					return true
-				}
-			}
-		}
-	}
-
-	return false
+	// Skip resources managed by operators or controllers that recreate them
+	return cb.skipOwnedResource(resource)
 }
 
 func (cb *ClusterBackup) validateResource(resource map[string]interface{}) error {
@@ -983,16 +1459,17 @@ func (cb *ClusterBackup) validateResource(resource map[string]interface{}) error
 	return err
 }
 
+// cleanResource returns the form of an object that is stored. It works on a
+// deep copy: the live object is still read afterwards, e.g. for its UID and
+// resourceVersion in the object metadata and for its references.
 func (cb *ClusterBackup) cleanResource(resource *unstructured.Unstructured) map[string]interface{} {
-	cleaned := make(map[string]interface{})
-	for k, v := range resource.Object {
-		cleaned[k] = v
-	}
+	cleaned := resource.DeepCopy().Object
 
 	// Always remove status unless specifically included
-	if !cb.backupConfig.IncludeStatus {
+	if !cb.includeStatusFor(resource) {
 		delete(cleaned, "status")
 	}
+	cb.redactFor(resource, cleaned)
 
 	// Clean metadata
 	if metadata, ok := cleaned["metadata"].(map[string]interface{}); ok {
@@ -1007,36 +1484,61 @@ func (cb *ClusterBackup) cleanResource(resource *unstructured.Unstructured) map[
 			delete(metadata, "managedFields")
 		}
 	}
+	cb.cleanOpenShiftResource(resource, cleaned)
 
 	return cleaned
 }
 
-func (cb *ClusterBackup) uploadResource(namespace, resourceType, name string, resource map[string]interface{}) error {
+func (cb *ClusterBackup) uploadResource(namespace string, gvr schema.GroupVersionResource, item *unstructured.Unstructured, resource map[string]interface{}) error {
 	yamlData, err := yaml.Marshal(resource)
 	if err != nil {
 		return fmt.Errorf("failed to marshal resource to YAML: %v", err)
 	}
 
 	// Multi-cluster centralized path structure: clusterbackup/{cluster-name}/{namespace}/{resource-type}/{resource-name}.yaml
-	objectPath := fmt.Sprintf("clusterbackup/%s/%s/%s/%s.yaml",
-		cb.config.ClusterName,
-		namespace,
-		resourceType,
-		name,
-	)
+	// or clusterbackup/{cluster-name}/snapshots/{run-id}/... in snapshot mode
+	objectPath := cb.objectKey(namespace, gvr.Resource, item.GetName())
 
-	_, err = cb.minioClient.PutObject(
+	userMetadata, userTags := cb.backupObjectMetadata(namespace, gvr, item, yamlData)
+	putOptions := minio.PutObjectOptions{
+		ContentType:          "application/x-yaml",
+		ServerSideEncryption: cb.sse,
+		UserMetadata:         userMetadata,
+		UserTags:             userTags,
+	}
+	cb.applyObjectLock(&putOptions)
+
+	uploadInfo, err := cb.minioClient.PutObject(
 		cb.ctx,
 		cb.config.MinIOBucket,
 		objectPath,
 		strings.NewReader(string(yamlData)),
 		int64(len(yamlData)),
-		minio.PutObjectOptions{
-			ContentType: "application/x-yaml",
-		},
+		putOptions,
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	if cb.backupConfig.VerifyUploads {
+		if err := cb.verifyUpload(objectPath, yamlData, uploadInfo); err != nil {
+			cb.metrics.VerifyFailures.Inc()
+			return fmt.Errorf("verification of %s failed: %v", objectPath, err)
+		}
+	}
+
+	cb.recordManifestEntry(cb.manifestEntry(objectPath, namespace, gvr, item, userMetadata, int64(len(yamlData))))
+	return nil
+}
+
+// getObjectOptions and statObjectOptions carry the SSE-C key needed to read
+// or stat objects written with customer-provided encryption.
+func (cb *ClusterBackup) getObjectOptions() minio.GetObjectOptions {
+	return minio.GetObjectOptions{ServerSideEncryption: shared.ReadEncryption(cb.sse)}
+}
+
+func (cb *ClusterBackup) statObjectOptions() minio.StatObjectOptions {
+	return minio.StatObjectOptions{ServerSideEncryption: shared.ReadEncryption(cb.sse)}
 }
 
 func containsVerb(verbs []string, verb string) bool {
@@ -1059,6 +1561,7 @@ func NewStructuredLogger(component, clusterName string) *StructuredLogger {
 	return &StructuredLogger{
 		clusterName: clusterName,
 		logLevel:    getSecretValue("LOG_LEVEL", "info"),
+		output:      os.Stdout,
 	}
 }
 
@@ -1091,7 +1594,7 @@ func (sl *StructuredLogger) log(level, operation, message string, data map[strin
 	}
 	
 	logJSON, _ := json.Marshal(entry)
-	fmt.Println(string(logJSON))
+	fmt.Fprintln(sl.output, string(logJSON))
 	
 	// Also log to standard logger for backward compatibility
 	if level == "error" || level == "fatal" {
@@ -1143,87 +1646,178 @@ func (cb *ClusterBackup) performCleanup() error {
 		cb.logger.Debug("cleanup_skip", "Cleanup disabled in configuration", nil)
 		return nil
 	}
+	cb.cleanupStats = cleanupStats{}
+	// Never clean up data of another cluster with the same name
+	if err := cb.ensureClusterMarker(); err != nil {
+		return err
+	}
+
+	if cb.backupConfig.RetentionPolicy == retentionPolicyGFS {
+		if cb.backupConfig.SnapshotMode {
+			cb.logger.Info("cleanup_start", "Starting GFS snapshot cleanup", map[string]interface{}{
+				"cluster": cb.config.ClusterName,
+				"policy": cb.backupConfig.GFS,
+				"dry_run": cb.backupConfig.CleanupDryRun,
+			})
+			return cb.performGFSCleanup()
+		}
+		cb.logger.Warn("cleanup_gfs_unavailable", "GFS retention requires snapshot-mode, falling back to age-based retention", map[string]interface{}{
+			"retention_days": cb.backupConfig.RetentionDays,
+		})
+	}
+
+	if err := cb.ensureLifecycle(); err != nil {
+		cb.logger.Warn("lifecycle_check_failed", "Bucket lifecycle verification failed, using client-side cleanup", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	if cb.lifecycleActive {
+		cb.logger.Info("cleanup_skip", "Expiration is handled by bucket lifecycle rules", map[string]interface{}{
+			"rule_id": cb.lifecycleRuleID(),
+		})
+		return nil
+	}
 
 	cb.logger.Info("cleanup_start", "Starting backup cleanup process", map[string]interface{}{
 		"retention_days": cb.backupConfig.RetentionDays,
+		"retention_rules": len(cb.backupConfig.RetentionRules),
 		"cluster": cb.config.ClusterName,
 	})
 
 	startTime := time.Now()
 	cutoffTime := startTime.AddDate(0, 0, -cb.backupConfig.RetentionDays)
 	
-	// List all objects for this cluster
-	prefix := fmt.Sprintf("clusterbackup/%s", cb.config.ClusterName)
+	// Objects written by pinned runs survive until the run is unpinned
+	pins, err := cb.pinnedRuns()
+	if err != nil {
+		return fmt.Errorf("failed to read pinned runs: %v", err)
+	}
+
+	// List all objects for this cluster. The trailing slash keeps the prefix
+	// of cluster "prod" from matching "prod-east".
+	prefix := cb.clusterPrefix()
+	// MinIO returns user metadata in listings, which saves a stat per object
+	// when retention rules match on kind, group or labels, or when pinned
+	// runs have to be recognized by their run-id metadata
 	objects := cb.minioClient.ListObjects(cb.ctx, cb.config.MinIOBucket, minio.ListObjectsOptions{
-		Prefix: prefix,
+		Prefix:       prefix,
+		Recursive:    true,
+		WithMetadata: cb.retentionRulesNeedMetadata() || (len(pins) > 0 && !cb.backupConfig.SnapshotMode),
 	})
 
-	var cleanedCount int
-	var cleanedSize int64
+	// Objects under retention or legal hold cannot be deleted, so they are
+	// skipped instead of being reported as cleanup failures
+	objectLock, err := cb.bucketHasObjectLock()
+	if err != nil {
+		cb.logger.Warn("cleanup_object_lock_check", "Could not determine bucket object lock status", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	var candidates []minio.ObjectInfo
+	candidateRules := make(map[string]string)
+	var candidateSize int64
+	var retainedCount int
+	var pinnedCount int
 	var errors []string
 
 	for object := range objects {
 		if object.Err != nil {
+			errors = append(errors, fmt.Sprintf("list %s: %v", prefix, object.Err))
 			cb.logger.Error("cleanup_list_error", "Error listing object during cleanup", map[string]interface{}{
 				"error": object.Err.Error(),
 			})
 			continue
 		}
 
-		// Check if object is older than retention period
-		if object.LastModified.Before(cutoffTime) {
-			err := cb.minioClient.RemoveObject(cb.ctx, cb.config.MinIOBucket, object.Key, minio.RemoveObjectOptions{})
+		// Check if object is older than its retention period
+		objectCutoff, rule := cb.objectCutoff(object, startTime)
+		if !object.LastModified.Before(objectCutoff) {
+			continue
+		}
+
+		if len(pins) > 0 {
+			if _, pinned := pins[cb.objectRunID(object)]; pinned {
+				pinnedCount++
+				continue
+			}
+		}
+
+		if objectLock {
+			reason, err := cb.objectRetentionReason(object.Key, startTime)
 			if err != nil {
-				errorMsg := fmt.Sprintf("Failed to remove %s: %v", object.Key, err)
-				errors = append(errors, errorMsg)
-				cb.logger.Error("cleanup_remove_error", "Failed to remove old backup file", map[string]interface{}{
+				cb.logger.Warn("cleanup_retention_check", "Failed to read object retention", map[string]interface{}{
 					"object_key": object.Key,
 					"error": err.Error(),
 				})
-			} else {
-				cleanedCount++
-				cleanedSize += object.Size
-				cb.logger.Debug("cleanup_remove_success", "Removed old backup file", map[string]interface{}{
+			}
+			if reason != "" {
+				retainedCount++
+				cb.logger.Debug("cleanup_skip_retained", "Skipping object protected by object lock", map[string]interface{}{
 					"object_key": object.Key,
-					"size": object.Size,
-					"last_modified": object.LastModified,
+					"reason": reason,
 				})
+				continue
 			}
 		}
+
+		candidates = append(candidates, object)
+		candidateRules[object.Key] = rule
+		candidateSize += object.Size
 	}
 
+	if cb.backupConfig.CleanupDryRun {
+		report := make([]map[string]interface{}, 0, len(candidates))
+		for _, object := range candidates {
+			report = append(report, map[string]interface{}{
+				"object_key": object.Key,
+				"size": object.Size,
+				"last_modified": object.LastModified,
+				"retention_rule": candidateRules[object.Key],
+			})
+		}
+		cb.logger.Info("cleanup_dry_run_report", "Cleanup dry run completed, no objects were deleted", map[string]interface{}{
+			"default_cutoff": cutoffTime.UTC().Format(time.RFC3339),
+			"candidate_files": len(candidates),
+			"candidate_size_bytes": candidateSize,
+			"retained_files": retainedCount,
+			"pinned_files": pinnedCount,
+			"candidates": report,
+			"duration_ms": time.Since(startTime).Milliseconds(),
+		})
+		if len(errors) > 0 {
+			return fmt.Errorf("cleanup dry run incomplete: %s", errors[0])
+		}
+		return nil
+	}
+
+	cleanedCount, cleanedSize, removeErrors := cb.removeObjects(candidates)
+	errors = append(errors, removeErrors...)
+	cb.cleanupStats = cleanupStats{CleanedFiles: cleanedCount, CleanedBytes: cleanedSize}
+
 	duration := time.Since(startTime)
 	
 	if len(errors) > 0 {
 		cb.logger.Warn("cleanup_complete_with_errors", "Cleanup completed with some errors", map[string]interface{}{
 			"cleaned_files": cleanedCount,
 			"cleaned_size_bytes": cleanedSize,
+			"retained_files": retainedCount,
+			"pinned_files": pinnedCount,
 			"errors_count": len(errors),
 			"duration_ms": duration.Milliseconds(),
 		})
-	} else {
-		cb.logger.Info("cleanup_complete", "Cleanup completed successfully", map[string]interface{}{
-			"cleaned_files": cleanedCount,
-			"cleaned_size_bytes": cleanedSize,
-			"duration_ms": duration.Milliseconds(),
-		})
+		return fmt.Errorf("cleanup failed with %d errors, first: %s", len(errors), errors[0])
 	}
 
-	return nil
-}
+	cb.logger.Info("cleanup_complete", "Cleanup completed successfully", map[string]interface{}{
+		"cleaned_files": cleanedCount,
+		"cleaned_size_bytes": cleanedSize,
+		"retained_files": retainedCount,
+		"pinned_files": pinnedCount,
+		"duration_ms": duration.Milliseconds(),
+	})
 
-func (cb *ClusterBackup) addBackupMetadata(obj *unstructured.Unstructured) {
-	// Add backup metadata to the object
-	annotations := obj.GetAnnotations()
-	if annotations == nil {
-		annotations = make(map[string]string)
-	}
-	
-	annotations["backup.cluster/timestamp"] = time.Now().Format(time.RFC3339)
-	annotations["backup.cluster/cluster"] = cb.config.ClusterName
-	annotations["backup.cluster/version"] = "1.0.0"
-	
-	obj.SetAnnotations(annotations)
+	return nil
 }
 
 func (cb *ClusterBackup) shouldCleanupOnStartup() bool {
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// toolVersion is recorded on every uploaded object.
+const toolVersion = "1.0.0"
+
+// User metadata keys attached to uploaded objects. minio-go sends them as
+// X-Amz-Meta-<key>; the same keys are used for object tags where they fit.
+const (
+	metaCluster         = "cluster"
+	metaNamespace       = "namespace"
+	metaGroup           = "group"
+	metaVersion         = "version"
+	metaKind            = "kind"
+	metaName            = "name"
+	metaSourceUID       = "source-uid"
+	metaResourceVersion = "source-resource-version"
+	metaRunID           = "run-id"
+	metaContentSHA256   = "content-sha256"
+	metaToolVersion     = "tool-version"
+	metaLabels          = "labels"
+)
+
+// maxLabelsMetadataSize keeps the labels entry well within the 2 KB S3 limit
+// on user metadata; objects with larger label sets are stored without it.
+const maxLabelsMetadataSize = 1024
+
+// taggedMetadataKeys lists the metadata keys that are also written as S3
+// object tags. S3 allows at most 10 tags per object, so the per-revision
+// identifiers (UID, resourceVersion) are only kept in user metadata.
+var taggedMetadataKeys = []string{
+	metaCluster, metaNamespace, metaGroup, metaVersion, metaKind,
+	metaName, metaRunID, metaContentSHA256, metaToolVersion,
+}
+
+// newRunID derives the identifier of a backup run from its start time.
+func newRunID(start time.Time) string {
+	return start.UTC().Format("20060102T150405Z")
+}
+
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// backupObjectMetadata builds the user metadata and tags describing the
+// serialized form of a live object.
+func (cb *ClusterBackup) backupObjectMetadata(namespace string, gvr schema.GroupVersionResource, item *unstructured.Unstructured, yamlData []byte) (map[string]string, map[string]string) {
+	group := gvr.Group
+	if group == "" {
+		group = "core"
+	}
+
+	metadata := map[string]string{
+		metaCluster:         cb.config.ClusterName,
+		metaNamespace:       namespace,
+		metaGroup:           group,
+		metaVersion:         gvr.Version,
+		metaKind:            item.GetKind(),
+		metaName:            item.GetName(),
+		metaSourceUID:       string(item.GetUID()),
+		metaResourceVersion: item.GetResourceVersion(),
+		metaRunID:           cb.runID,
+		metaContentSHA256:   contentHash(yamlData),
+		metaToolVersion:     toolVersion,
+	}
+
+	if objectLabels := labels.Set(item.GetLabels()).String(); objectLabels != "" && len(objectLabels) <= maxLabelsMetadataSize {
+		metadata[metaLabels] = objectLabels
+	}
+
+	tags := make(map[string]string, len(taggedMetadataKeys))
+	for _, key := range taggedMetadataKeys {
+		tags[key] = metadata[key]
+	}
+
+	return metadata, tags
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestCleanResourceKeepsSourceMetadata(t *testing.T) {
+	item := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":              "settings",
+			"namespace":         "app",
+			"uid":               "0b5c7d9e-uid",
+			"resourceVersion":   "4711",
+			"generation":        int64(3),
+			"creationTimestamp": "2024-03-01T10:00:00Z",
+			"managedFields":     []interface{}{map[string]interface{}{"manager": "kubectl"}},
+			"labels":            map[string]interface{}{"app": "web"},
+		},
+		"data":   map[string]interface{}{"key": "value"},
+		"status": map[string]interface{}{"phase": "Active"},
+	}}
+	live := item.DeepCopy()
+
+	cb := &ClusterBackup{
+		config:       &Config{ClusterName: "c1"},
+		backupConfig: &BackupConfig{},
+		runID:        "20240301T120000Z",
+	}
+	cleaned := cb.cleanResource(item)
+
+	if !reflect.DeepEqual(item.Object, live.Object) {
+		t.Errorf("cleanResource modified the live object:\n%v\nwant\n%v", item.Object, live.Object)
+	}
+	metadata := cleaned["metadata"].(map[string]interface{})
+	for _, field := range []string{"uid", "resourceVersion", "generation", "creationTimestamp", "managedFields"} {
+		if _, ok := metadata[field]; ok {
+			t.Errorf("cleaned object still has metadata.%s", field)
+		}
+	}
+	if _, ok := cleaned["status"]; ok {
+		t.Errorf("cleaned object still has status")
+	}
+
+	userMetadata, tags := cb.backupObjectMetadata("app", schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}, item, []byte("data"))
+	want := map[string]string{
+		metaSourceUID:       "0b5c7d9e-uid",
+		metaResourceVersion: "4711",
+		metaGroup:           "core",
+		metaKind:            "ConfigMap",
+		metaLabels:          "app=web",
+		metaRunID:           "20240301T120000Z",
+	}
+	for key, value := range want {
+		if userMetadata[key] != value {
+			t.Errorf("metadata %s = %q, want %q", key, userMetadata[key], value)
+		}
+	}
+	if _, ok := tags[metaSourceUID]; ok {
+		t.Errorf("source UID must not be written as a tag")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Namespace-level objects stored with each namespace, so the namespace can be
+// recreated before its content:
+//
+//	{prefix}{namespace}/namespaces/{namespace}.yaml
+//	{prefix}{namespace}/projects/{namespace}.yaml (OpenShift)
+//	{prefix}{namespace}/resourcequotas/{name}.yaml
+//	{prefix}{namespace}/limitranges/{name}.yaml
+var (
+	namespacesGVR     = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+	resourceQuotasGVR = schema.GroupVersionResource{Version: "v1", Resource: "resourcequotas"}
+	limitRangesGVR    = schema.GroupVersionResource{Version: "v1", Resource: "limitranges"}
+	projectsGVR       = schema.GroupVersionResource{Group: "project.openshift.io", Version: "v1", Resource: "projects"}
+)
+
+// backupNamespaceMetadata stores the Namespace object of a namespace, its
+// ResourceQuotas and LimitRanges and, on OpenShift, its Project. It returns
+// the number of objects stored.
+func (cb *ClusterBackup) backupNamespaceMetadata(namespace string) (int, error) {
+	items, gvrs, err := cb.namespaceMetadataItems(namespace)
+	if err != nil {
+		return 0, err
+	}
+
+	stored := 0
+	var errors []string
+	for i, item := range items {
+		if cb.excludedByAnnotation(item) {
+			continue
+		}
+		if err := cb.uploadResource(namespace, gvrs[i], item, cb.cleanResource(item)); err != nil {
+			errors = append(errors, fmt.Sprintf("%s %s: %v", gvrs[i].Resource, item.GetName(), err))
+			continue
+		}
+		cb.metrics.ResourcesBackedUp.Inc()
+		stored++
+	}
+
+	if len(errors) > 0 {
+		return stored, fmt.Errorf("%d namespace objects failed, first: %s", len(errors), errors[0])
+	}
+	return stored, nil
+}
+
+// namespaceMetadataItems reads the namespace-level objects of a namespace
+// and their resource types, as stored by backupNamespaceMetadata.
+func (cb *ClusterBackup) namespaceMetadataItems(namespace string) ([]*unstructured.Unstructured, []schema.GroupVersionResource, error) {
+	var items []*unstructured.Unstructured
+	var gvrs []schema.GroupVersionResource
+
+	ns, err := cb.dynamicClient.Resource(namespacesGVR).Get(cb.ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get namespace: %w", err)
+	}
+	items = append(items, ns)
+	gvrs = append(gvrs, namespacesGVR)
+
+	if cb.isOpenShift() {
+		project, err := cb.dynamicClient.Resource(projectsGVR).Get(cb.ctx, namespace, metav1.GetOptions{})
+		switch {
+		case err == nil:
+			items = append(items, project)
+			gvrs = append(gvrs, projectsGVR)
+		case apierrors.IsForbidden(err):
+			cb.recordWarning("ProjectForbidden", fmt.Sprintf("Not allowed to read project %s, its metadata is missing from the backup", namespace))
+		case !apierrors.IsNotFound(err):
+			return nil, nil, fmt.Errorf("failed to get project: %w", err)
+		}
+	}
+
+	for _, gvr := range []schema.GroupVersionResource{resourceQuotasGVR, limitRangesGVR} {
+		list, err := cb.dynamicClient.Resource(gvr).Namespace(namespace).List(cb.ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list %s: %w", gvr.Resource, err)
+		}
+		for i := range list.Items {
+			items = append(items, &list.Items[i])
+			gvrs = append(gvrs, gvr)
+		}
+	}
+	return items, gvrs, nil
+}
+
+// isNamespaceMetadataResource reports whether backupNamespaceMetadata stores
+// a resource type, so the resource loop leaves it out.
+func isNamespaceMetadataResource(gvr schema.GroupVersionResource) bool {
+	return gvr == namespacesGVR || gvr == projectsGVR || gvr == resourceQuotasGVR || gvr == limitRangesGVR
+}
+
+// skipInResourceLoop reports whether the per-namespace resource loop leaves
+// a resource type out. Namespaces and Projects are never listed there: as
+// cluster-scoped types every namespace would store all of them, and
+// restoring one namespace would recreate every other.
+func (cb *ClusterBackup) skipInResourceLoop(gvr schema.GroupVersionResource) bool {
+	if gvr == namespacesGVR || gvr == projectsGVR {
+		return true
+	}
+	return cb.backupConfig.NamespaceMetadata && isNamespaceMetadataResource(gvr)
+}
+
+// isProjectKey reports whether a key relative to a backup prefix holds an
+// OpenShift Project. Projects are views of their Namespace and are recreated
+// with it, they are stored for reference only.
+func isProjectKey(rel string) bool {
+	_, resourceType, _, ok := splitRelativeKey(rel)
+	return ok && resourceType == projectsGVR.Resource
+}
+
+// restorePriority orders restored objects: CRDs, then Namespaces, then the
+// quotas and limit ranges that constrain what is created in them, then
+// everything else.
+func restorePriority(rel string) int {
+	_, resourceType, _, _ := splitRelativeKey(rel)
+	switch {
+	case resourceType == crdGVR.Resource:
+		return 0
+	case resourceType == namespacesGVR.Resource:
+		return 1
+	case resourceType == resourceQuotasGVR.Resource, resourceType == limitRangesGVR.Resource:
+		return 2
+	default:
+		return 3
+	}
+}
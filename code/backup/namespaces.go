@@ -0,0 +1,153 @@
+package main
+
+import (
+	"log"
+	"path"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// namespaceOptInAnnotation on a Namespace object opts it into the backup.
+const namespaceOptInAnnotation = "backup.cluster/enabled"
+
+// namespacePattern is one entry of include-namespaces or exclude-namespaces:
+// an exact name, a glob such as team-*, or a regular expression written as
+// /expr/. A leading ! in include-namespaces excludes matching namespaces.
+type namespacePattern struct {
+	text    string
+	negated bool
+	literal bool
+	regex   *regexp.Regexp
+}
+
+func parseNamespacePatterns(entries []string) []namespacePattern {
+	var patterns []namespacePattern
+	for _, entry := range entries {
+		pattern := namespacePattern{}
+		if strings.HasPrefix(entry, "!") {
+			pattern.negated = true
+			entry = strings.TrimSpace(entry[1:])
+		}
+		pattern.text = entry
+		if len(entry) > 2 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/") {
+			regex, err := regexp.Compile(entry[1 : len(entry)-1])
+			if err != nil {
+				log.Printf("Warning: ignoring invalid namespace pattern %s: %v", entry, err)
+				continue
+			}
+			pattern.regex = regex
+		} else {
+			if _, err := path.Match(entry, ""); err != nil {
+				log.Printf("Warning: ignoring invalid namespace pattern %s: %v", entry, err)
+				continue
+			}
+			pattern.literal = !strings.ContainsAny(entry, "*?[")
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+func (p namespacePattern) matches(namespace string) bool {
+	if p.regex != nil {
+		return p.regex.MatchString(namespace)
+	}
+	matched, _ := path.Match(p.text, namespace)
+	return matched
+}
+
+// namespaceSelection decides which namespaces are backed up.
+type namespaceSelection struct {
+	include  []namespacePattern
+	negated  []namespacePattern
+	exclude  []namespacePattern
+	selector labels.Selector
+	optIn    bool
+}
+
+func (cb *ClusterBackup) newNamespaceSelection() (*namespaceSelection, error) {
+	selection := &namespaceSelection{
+		exclude: parseNamespacePatterns(cb.backupConfig.ExcludeNamespaces),
+		optIn:   cb.backupConfig.RequireNamespaceOptIn,
+	}
+	for _, pattern := range parseNamespacePatterns(cb.backupConfig.IncludeNamespaces) {
+		if pattern.negated {
+			selection.negated = append(selection.negated, pattern)
+			continue
+		}
+		selection.include = append(selection.include, pattern)
+	}
+	if cb.backupConfig.NamespaceSelector != "" {
+		selector, err := labels.Parse(cb.backupConfig.NamespaceSelector)
+		if err != nil {
+			return nil, err
+		}
+		selection.selector = selector
+	}
+	return selection, nil
+}
+
+// selected applies the selection to a namespace. Namespaces annotated with
+// backup.cluster/exclude are never backed up. Namespaces named exactly in
+// include-namespaces are backed up even if an exclude pattern, such as the
+// default system namespaces, matches them; !patterns always exclude. The
+// opt-in annotation adds namespaces that no include pattern or selector
+// matches.
+func (s *namespaceSelection) selected(namespace *corev1.Namespace) bool {
+	name := namespace.Name
+	if namespace.Annotations[overrideExclude] == "true" {
+		return false
+	}
+	for _, pattern := range s.negated {
+		if pattern.matches(name) {
+			return false
+		}
+	}
+	for _, pattern := range s.include {
+		if pattern.literal && pattern.text == name {
+			return true
+		}
+	}
+	for _, pattern := range s.exclude {
+		if pattern.matches(name) {
+			return false
+		}
+	}
+	if namespace.Annotations[namespaceOptInAnnotation] == "true" {
+		return true
+	}
+	if s.optIn {
+		return false
+	}
+	if len(s.include) > 0 {
+		included := false
+		for _, pattern := range s.include {
+			if pattern.matches(name) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	if s.selector != nil && !s.selector.Matches(labels.Set(namespace.Labels)) {
+		return false
+	}
+	return true
+}
+
+// missingIncludes lists the exact names in include-namespaces that do not
+// exist.
+func (s *namespaceSelection) missingIncludes(existing map[string]bool) []string {
+	var missing []string
+	for _, pattern := range s.include {
+		if pattern.literal && !existing[pattern.text] {
+			missing = append(missing, pattern.text)
+		}
+	}
+	return missing
+}
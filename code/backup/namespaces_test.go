@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNamespaceSelectionSelected(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      BackupConfig
+		namespace   string
+		labels      map[string]string
+		annotations map[string]string
+		want        bool
+	}{
+		{
+			name:      "everything by default",
+			namespace: "team-a",
+			want:      true,
+		},
+		{
+			name:      "exclude pattern",
+			config:    BackupConfig{ExcludeNamespaces: []string{"kube-*"}},
+			namespace: "kube-system",
+			want:      false,
+		},
+		{
+			name:      "exact include beats exclude",
+			config:    BackupConfig{IncludeNamespaces: []string{"kube-system"}, ExcludeNamespaces: []string{"kube-*"}},
+			namespace: "kube-system",
+			want:      true,
+		},
+		{
+			name:      "include glob does not beat exclude",
+			config:    BackupConfig{IncludeNamespaces: []string{"kube-*"}, ExcludeNamespaces: []string{"kube-system"}},
+			namespace: "kube-system",
+			want:      false,
+		},
+		{
+			name:      "negated include beats exact include",
+			config:    BackupConfig{IncludeNamespaces: []string{"team-a", "!team-*"}},
+			namespace: "team-a",
+			want:      false,
+		},
+		{
+			name:      "not matched by include",
+			config:    BackupConfig{IncludeNamespaces: []string{"team-*"}},
+			namespace: "other",
+			want:      false,
+		},
+		{
+			name:      "regular expression include",
+			config:    BackupConfig{IncludeNamespaces: []string{"/^team-[0-9]+$/"}},
+			namespace: "team-42",
+			want:      true,
+		},
+		{
+			name:        "exclude annotation beats exact include",
+			config:      BackupConfig{IncludeNamespaces: []string{"team-a"}},
+			namespace:   "team-a",
+			annotations: map[string]string{overrideExclude: "true"},
+			want:        false,
+		},
+		{
+			name:        "opt-in annotation adds unmatched namespace",
+			config:      BackupConfig{IncludeNamespaces: []string{"team-*"}},
+			namespace:   "other",
+			annotations: map[string]string{namespaceOptInAnnotation: "true"},
+			want:        true,
+		},
+		{
+			name:        "opt-in annotation does not beat exclude",
+			config:      BackupConfig{ExcludeNamespaces: []string{"other"}},
+			namespace:   "other",
+			annotations: map[string]string{namespaceOptInAnnotation: "true"},
+			want:        false,
+		},
+		{
+			name:      "opt-in required",
+			config:    BackupConfig{RequireNamespaceOptIn: true},
+			namespace: "team-a",
+			want:      false,
+		},
+		{
+			name:      "selector",
+			config:    BackupConfig{NamespaceSelector: "backup=true"},
+			namespace: "team-a",
+			labels:    map[string]string{"backup": "false"},
+			want:      false,
+		},
+		{
+			name:      "selector and include",
+			config:    BackupConfig{IncludeNamespaces: []string{"team-*"}, NamespaceSelector: "backup=true"},
+			namespace: "team-a",
+			labels:    map[string]string{"backup": "true"},
+			want:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			cb := &ClusterBackup{backupConfig: &config}
+			selection, err := cb.newNamespaceSelection()
+			if err != nil {
+				t.Fatalf("newNamespaceSelection: %v", err)
+			}
+			namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name:        tt.namespace,
+				Labels:      tt.labels,
+				Annotations: tt.annotations,
+			}}
+			if got := selection.selected(namespace); got != tt.want {
+				t.Errorf("selected(%s) = %v, want %v", tt.namespace, got, tt.want)
+			}
+		})
+	}
+}
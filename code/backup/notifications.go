@@ -0,0 +1,131 @@
+package main
+
+import (
+	"time"
+
+	"shared"
+)
+
+// runStats collects the outcome of a backup run for notifications.
+type runStats struct {
+	StartedAt  time.Time
+	Namespaces int
+	Resources  int
+	Failures   map[string]string // namespace -> error
+	// ResourceErrors counts failed resource types of stored namespaces
+	ResourceErrors int
+	Warnings       []string
+	Changed        bool
+}
+
+// cleanupStats collects the outcome of a cleanup for notifications.
+type cleanupStats struct {
+	CleanedFiles int
+	CleanedBytes int64
+}
+
+// manifestChanged reports whether this run stored different objects or
+// content than the previous run. Without a previous manifest every run
+// counts as a change.
+func (cb *ClusterBackup) manifestChanged(previous *RunManifest) bool {
+	if previous == nil {
+		return true
+	}
+
+	cb.manifestMu.Lock()
+	defer cb.manifestMu.Unlock()
+
+	// Compare by object identity, not key, so snapshot runs compare equal
+	// when nothing changed
+	identity := func(entry ManifestEntry) string {
+		return entry.Namespace + "/" + entry.Group + "/" + entry.Kind + "/" + entry.Name
+	}
+	hashes := make(map[string]string, len(previous.Objects))
+	for _, entry := range previous.Objects {
+		hashes[identity(entry)] = entry.SHA256
+	}
+	if len(hashes) != len(cb.manifest) {
+		return true
+	}
+	for _, entry := range cb.manifest {
+		if hash, ok := hashes[identity(entry)]; !ok || hash != entry.SHA256 {
+			return true
+		}
+	}
+	return false
+}
+
+// previousManifest returns the manifest of the newest recorded run, nil if
+// there is none.
+func (cb *ClusterBackup) previousManifest() (*RunManifest, error) {
+	records, err := cb.listRunRecords()
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return cb.readManifest(records[0].RunID)
+}
+
+// notifyBackup sends the outcome of Run to the configured webhooks.
+func (cb *ClusterBackup) notifyBackup(runErr error) {
+	notification := &shared.Notification{
+		Source:          "backup",
+		Cluster:         cb.config.ClusterName,
+		RunID:           cb.runID,
+		Status:          "success",
+		Changed:         cb.runStats.Changed,
+		StartedAt:       cb.runStats.StartedAt.UTC(),
+		DurationSeconds: time.Since(cb.runStats.StartedAt).Seconds(),
+		Summary: map[string]interface{}{
+			"namespaces": cb.runStats.Namespaces,
+			"resources":  cb.runStats.Resources,
+		},
+		Failures: cb.runStats.Failures,
+	}
+	if runErr != nil {
+		notification.Status = "failure"
+		notification.Error = runErr.Error()
+		notification.Changed = true
+	} else if len(cb.runStats.Failures) > 0 {
+		notification.Status = "failure"
+	}
+	cb.sendNotification(notification)
+}
+
+// notifyCleanup sends the outcome of a cleanup to the configured webhooks.
+func (cb *ClusterBackup) notifyCleanup(startedAt time.Time, cleanupErr error) {
+	notification := &shared.Notification{
+		Source:          "cleanup",
+		Cluster:         cb.config.ClusterName,
+		RunID:           cb.runID,
+		Status:          "success",
+		Changed:         cb.cleanupStats.CleanedFiles > 0,
+		StartedAt:       startedAt.UTC(),
+		DurationSeconds: time.Since(startedAt).Seconds(),
+		Summary: map[string]interface{}{
+			"cleaned_files":      cb.cleanupStats.CleanedFiles,
+			"cleaned_size_bytes": cb.cleanupStats.CleanedBytes,
+			"dry_run":            cb.backupConfig.CleanupDryRun,
+		},
+	}
+	if cleanupErr != nil {
+		notification.Status = "failure"
+		notification.Error = cleanupErr.Error()
+	}
+	cb.sendNotification(notification)
+}
+
+func (cb *ClusterBackup) sendNotification(notification *shared.Notification) {
+	sent, errors := cb.notifier.Send(notification)
+	for _, err := range errors {
+		cb.logger.Error("notification_failed", "Failed to send notification", map[string]interface{}{
+			"source": notification.Source,
+			"error":  err.Error(),
+		})
+	}
+	if sent && len(errors) == 0 {
+		cb.logger.Debug("notification_sent", "Notification sent", map[string]interface{}{
+			"source": notification.Source,
+			"status": notification.Status,
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// openShiftProfileResources are backed up on OpenShift with
+// include-openshift-resources, whatever the resource filters say.
+// RoleBindings carry the namespace's SCC grants.
+var openShiftProfileResources = map[string]bool{
+	"routes.route.openshift.io":              true,
+	"buildconfigs.build.openshift.io":        true,
+	"imagestreams.image.openshift.io":        true,
+	"deploymentconfigs.apps.openshift.io":    true,
+	"templates.template.openshift.io":        true,
+	"rolebindings.rbac.authorization.k8s.io": true,
+}
+
+var clusterRoleBindingsGVR = schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterrolebindings"}
+
+// sccClusterRolePrefix names the ClusterRoles that grant use of an SCC, as
+// created by oc adm policy add-scc-to-user.
+const sccClusterRolePrefix = "system:openshift:scc:"
+
+// Annotations OpenShift sets on generated objects.
+const (
+	annotationServiceAccountName   = "kubernetes.io/service-account.name"
+	annotationRegistryTokenAccount = "openshift.io/internal-registry-auth-token.service-account"
+	annotationRouteHostGenerated   = "openshift.io/host.generated"
+	annotationSCCUIDRangePrefix    = "openshift.io/sa.scc."
+	secretTypeDockercfg            = "kubernetes.io/dockercfg"
+	secretTypeServiceAccountToken  = "kubernetes.io/service-account-token"
+)
+
+// isOpenShift reports whether the cluster is OpenShift, detecting it once
+// when openshift-mode is auto-detect.
+func (cb *ClusterBackup) isOpenShift() bool {
+	if cb.backupConfig.OpenShiftMode == "auto-detect" {
+		cb.backupConfig.OpenShiftMode = cb.detectOpenShift()
+	}
+	return cb.backupConfig.OpenShiftMode == "enabled"
+}
+
+// openShiftProfile reports whether the OpenShift profile applies.
+func (cb *ClusterBackup) openShiftProfile() bool {
+	return cb.backupConfig.IncludeOpenShiftRes && cb.isOpenShift()
+}
+
+// inOpenShiftProfile reports whether the profile selects a resource type.
+func (cb *ClusterBackup) inOpenShiftProfile(resource metav1.APIResource) bool {
+	return cb.openShiftProfile() && openShiftProfileResources[resource.Name+"."+resource.Group]
+}
+
+// openShiftGeneratedSecret reports whether a Secret was generated by
+// OpenShift for a ServiceAccount: the registry pull secret (dockercfg) and
+// the token secret behind it. OpenShift recreates both for restored
+// ServiceAccounts; restoring them would leave stale credentials.
+func openShiftGeneratedSecret(item *unstructured.Unstructured) bool {
+	if item.GetKind() != "Secret" {
+		return false
+	}
+	annotations := item.GetAnnotations()
+	account := annotations[annotationServiceAccountName]
+	switch nestedString(item.Object, "type") {
+	case secretTypeDockercfg:
+		return account != "" || annotations[annotationRegistryTokenAccount] != ""
+	case secretTypeServiceAccountToken:
+		return account != "" && strings.HasPrefix(item.GetName(), account+"-token-")
+	}
+	return false
+}
+
+// skipOpenShiftGenerated applies the profile's skip rules to an object.
+func (cb *ClusterBackup) skipOpenShiftGenerated(item *unstructured.Unstructured) bool {
+	if !cb.openShiftProfile() || !openShiftGeneratedSecret(item) {
+		return false
+	}
+	cb.logger.Debug("openshift_generated_skipped", "Skipping secret generated by OpenShift", map[string]interface{}{
+		"namespace":     item.GetNamespace(),
+		"resource_name": item.GetName(),
+	})
+	return true
+}
+
+// cleanOpenShiftResource removes what OpenShift generates from a cleaned
+// object. ServiceAccounts lose their references to the skipped generated
+// secrets, e.g. the pull secrets of builder and deployer. With
+// strip-openshift-generated-fields, generated Route hosts, image stream
+// status tags and the SCC ranges OpenShift assigns to namespaces are dropped
+// as well, so a restore gets fresh ones. cleaned is a deep copy made by
+// cleanResource, the live object is left as it is.
+func (cb *ClusterBackup) cleanOpenShiftResource(item *unstructured.Unstructured, cleaned map[string]interface{}) {
+	if !cb.openShiftProfile() {
+		return
+	}
+	if item.GetKind() == "ServiceAccount" {
+		for _, field := range []string{"secrets", "imagePullSecrets"} {
+			refs, ok := cleaned[field].([]interface{})
+			if !ok {
+				continue
+			}
+			var kept []interface{}
+			for _, ref := range refs {
+				name := ""
+				if m, ok := ref.(map[string]interface{}); ok {
+					name, _ = m["name"].(string)
+				}
+				if !generatedSecretName(item.GetName(), name) {
+					kept = append(kept, ref)
+				}
+			}
+			if len(kept) == 0 {
+				delete(cleaned, field)
+				continue
+			}
+			cleaned[field] = kept
+		}
+	}
+
+	if !cb.backupConfig.StripOpenShiftFields {
+		return
+	}
+	if item.GetKind() == "Route" && item.GetAnnotations()[annotationRouteHostGenerated] == "true" {
+		unstructured.RemoveNestedField(cleaned, "spec", "host")
+	}
+	if item.GetKind() == "ImageStream" {
+		unstructured.RemoveNestedField(cleaned, "status", "tags")
+	}
+	if metadata, ok := cleaned["metadata"].(map[string]interface{}); ok {
+		if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+			kept := make(map[string]interface{}, len(annotations))
+			for key, value := range annotations {
+				if !strings.HasPrefix(key, annotationSCCUIDRangePrefix) {
+					kept[key] = value
+				}
+			}
+			metadata["annotations"] = kept
+		}
+	}
+}
+
+// generatedSecretName reports whether a ServiceAccount's secret reference
+// names one of the secrets OpenShift generates for it.
+func generatedSecretName(account, name string) bool {
+	return strings.HasPrefix(name, account+"-dockercfg-") || strings.HasPrefix(name, account+"-token-")
+}
+
+// sccBindings returns the ClusterRoleBindings that grant use of an SCC. The
+// list is read once per run.
+func (cb *ClusterBackup) sccBindings() ([]unstructured.Unstructured, error) {
+	if cb.sccBindingList != nil {
+		return cb.sccBindingList.Items, nil
+	}
+	list, err := cb.dynamicClient.Resource(clusterRoleBindingsGVR).List(cb.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cluster role bindings: %w", err)
+	}
+	var bindings []unstructured.Unstructured
+	for _, binding := range list.Items {
+		if strings.HasPrefix(nestedString(binding.Object, "roleRef", "name"), sccClusterRolePrefix) {
+			bindings = append(bindings, binding)
+		}
+	}
+	list.Items = bindings
+	cb.sccBindingList = list
+	return bindings, nil
+}
+
+// bindsNamespace reports whether a binding has a subject in namespace: one of
+// its ServiceAccounts or all of them.
+func bindsNamespace(binding *unstructured.Unstructured, namespace string) bool {
+	for _, subject := range nestedMaps(binding.Object, "subjects") {
+		switch nestedString(subject, "kind") {
+		case "ServiceAccount":
+			if nestedString(subject, "namespace") == namespace {
+				return true
+			}
+		case "Group":
+			if nestedString(subject, "name") == "system:serviceaccounts:"+namespace {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// backupSCCBindings stores the ClusterRoleBindings granting SCCs to the
+// ServiceAccounts of a namespace below the namespace, like other
+// cluster-scoped objects. RoleBindings are covered by the profile resources.
+func (cb *ClusterBackup) backupSCCBindings(namespace string) (int, error) {
+	bindings, err := cb.sccBindings()
+	if err != nil {
+		return 0, err
+	}
+	stored := 0
+	var errors []string
+	for i := range bindings {
+		binding := &bindings[i]
+		if !bindsNamespace(binding, namespace) || cb.manifestHas(cb.objectKey(namespace, clusterRoleBindingsGVR.Resource, binding.GetName())) {
+			continue
+		}
+		if err := cb.uploadResource(namespace, clusterRoleBindingsGVR, binding, cb.cleanResource(binding)); err != nil {
+			errors = append(errors, fmt.Sprintf("%s: %v", binding.GetName(), err))
+			continue
+		}
+		cb.metrics.ResourcesBackedUp.Inc()
+		stored++
+	}
+	if len(errors) > 0 {
+		return stored, fmt.Errorf("%d SCC bindings failed, first: %s", len(errors), errors[0])
+	}
+	return stored, nil
+}
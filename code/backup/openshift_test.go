@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestCleanOpenShiftResourceLeavesLiveObject(t *testing.T) {
+	tests := []struct {
+		name    string
+		object  map[string]interface{}
+		removed [][]string
+		kept    [][]string
+	}{
+		{
+			name: "namespace SCC ranges",
+			object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Namespace",
+				"metadata": map[string]interface{}{
+					"name": "app",
+					"annotations": map[string]interface{}{
+						"openshift.io/sa.scc.uid-range": "1000680000/10000",
+						"openshift.io/description":      "App",
+					},
+				},
+			},
+			removed: [][]string{{"metadata", "annotations", "openshift.io/sa.scc.uid-range"}},
+			kept:    [][]string{{"metadata", "annotations", "openshift.io/description"}},
+		},
+		{
+			name: "generated route host",
+			object: map[string]interface{}{
+				"apiVersion": "route.openshift.io/v1",
+				"kind":       "Route",
+				"metadata": map[string]interface{}{
+					"name":        "web",
+					"namespace":   "app",
+					"annotations": map[string]interface{}{annotationRouteHostGenerated: "true"},
+				},
+				"spec": map[string]interface{}{"host": "web-app.apps.example.com", "to": map[string]interface{}{"name": "web"}},
+			},
+			removed: [][]string{{"spec", "host"}},
+			kept:    [][]string{{"spec", "to", "name"}},
+		},
+		{
+			name: "image stream status tags",
+			object: map[string]interface{}{
+				"apiVersion": "image.openshift.io/v1",
+				"kind":       "ImageStream",
+				"metadata":   map[string]interface{}{"name": "web", "namespace": "app"},
+				"status": map[string]interface{}{
+					"dockerImageRepository": "registry/app/web",
+					"tags":                  []interface{}{map[string]interface{}{"tag": "latest"}},
+				},
+			},
+			removed: [][]string{{"status", "tags"}},
+		},
+	}
+
+	cb := &ClusterBackup{backupConfig: &BackupConfig{
+		OpenShiftMode:        "enabled",
+		IncludeOpenShiftRes:  true,
+		StripOpenShiftFields: true,
+		IncludeStatus:        true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := &unstructured.Unstructured{Object: tt.object}
+			live := item.DeepCopy()
+			cleaned := cb.cleanResource(item)
+
+			if !reflect.DeepEqual(item.Object, live.Object) {
+				t.Errorf("live object modified:\n%v\nwant\n%v", item.Object, live.Object)
+			}
+			for _, field := range tt.removed {
+				if _, found, _ := unstructured.NestedFieldNoCopy(cleaned, field...); found {
+					t.Errorf("cleaned object still has %v", field)
+				}
+			}
+			for _, field := range tt.kept {
+				if _, found, _ := unstructured.NestedFieldNoCopy(cleaned, field...); !found {
+					t.Errorf("cleaned object lost %v", field)
+				}
+			}
+		})
+	}
+}
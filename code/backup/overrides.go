@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Annotations application teams set on objects or namespaces to override
+// the backup configuration. Object annotations take precedence over
+// namespace annotations, which take precedence over backup-config.
+const (
+	overrideExclude       = "backup.cluster/exclude"
+	overrideIncludeStatus = "backup.cluster/include-status"
+	overrideRedact        = "backup.cluster/redact"
+)
+
+var overrideAnnotations = []string{overrideExclude, overrideIncludeStatus, overrideRedact}
+
+// redactedFields are the fields whose values redaction removes. Keys are
+// kept so a restore shows what has to be filled in.
+var redactedFields = []string{"data", "stringData", "binaryData"}
+
+// override returns the value of an override annotation for an object and
+// where it was set: "object", "namespace" or "" if not at all.
+func (cb *ClusterBackup) override(item *unstructured.Unstructured, annotation string) (string, string) {
+	if value, ok := item.GetAnnotations()[annotation]; ok {
+		return value, "object"
+	}
+	if value, ok := cb.namespaceAnnotations[item.GetNamespace()][annotation]; ok {
+		return value, "namespace"
+	}
+	return "", ""
+}
+
+// appliedOverrides lists the override annotations in effect for an object
+// as annotation=value (source), for the run manifest.
+func (cb *ClusterBackup) appliedOverrides(item *unstructured.Unstructured) []string {
+	var applied []string
+	for _, annotation := range overrideAnnotations {
+		if value, source := cb.override(item, annotation); source != "" {
+			applied = append(applied, annotation+"="+value+" ("+source+")")
+		}
+	}
+	return applied
+}
+
+// logOverride records that an annotation changed how an object is backed up.
+func (cb *ClusterBackup) logOverride(item *unstructured.Unstructured, annotation, value, source string) {
+	cb.logger.Info("resource_override", "Backup behavior overridden by annotation", map[string]interface{}{
+		"namespace":     item.GetNamespace(),
+		"kind":          item.GetKind(),
+		"resource_name": item.GetName(),
+		"annotation":    annotation,
+		"value":         value,
+		"source":        source,
+	})
+}
+
+// excludedByAnnotation reports whether an object or its namespace opted out
+// of the backup.
+func (cb *ClusterBackup) excludedByAnnotation(item *unstructured.Unstructured) bool {
+	value, source := cb.override(item, overrideExclude)
+	if value != "true" {
+		return false
+	}
+	cb.logOverride(item, overrideExclude, value, source)
+	return true
+}
+
+// includeStatusFor applies the include-status override to an object.
+func (cb *ClusterBackup) includeStatusFor(item *unstructured.Unstructured) bool {
+	value, source := cb.override(item, overrideIncludeStatus)
+	if value != "true" && value != "false" {
+		return cb.backupConfig.IncludeStatus
+	}
+	if (value == "true") != cb.backupConfig.IncludeStatus {
+		cb.logOverride(item, overrideIncludeStatus, value, source)
+	}
+	return value == "true"
+}
+
+// redactFor applies the redact override to a cleaned object, replacing the
+// values of its data fields with empty strings.
+func (cb *ClusterBackup) redactFor(item *unstructured.Unstructured, cleaned map[string]interface{}) {
+	value, source := cb.override(item, overrideRedact)
+	if value != "true" {
+		return
+	}
+	cb.logOverride(item, overrideRedact, value, source)
+	for _, field := range redactedFields {
+		data, ok := cleaned[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		keys := make([]string, 0, len(data))
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		redacted := make(map[string]interface{}, len(data))
+		for _, key := range keys {
+			redacted[key] = ""
+		}
+		cleaned[field] = redacted
+	}
+}
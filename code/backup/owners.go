@@ -0,0 +1,122 @@
+package main
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Handling of objects with a controller owner (owned-resources).
+const (
+	// ownedResourcesSkip drops owned objects, their owner recreates them
+	ownedResourcesSkip = "skip"
+	// ownedResourcesInclude backs up owned objects like any other
+	ownedResourcesInclude = "include"
+	// ownedResourcesUncaptured backs up owned objects only when the owner's
+	// kind is not backed up, e.g. objects created by operators whose custom
+	// resources are not captured
+	ownedResourcesUncaptured = "uncaptured-owners"
+)
+
+// OwnerLink is an owner reference of a stored object. Captured tells whether
+// the owner's kind is part of the backup.
+type OwnerLink struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	UID        string `json:"uid"`
+	Controller bool   `json:"controller,omitempty"`
+	Captured   bool   `json:"captured"`
+}
+
+// groupKind identifies a kind independent of its version.
+func groupKind(apiVersion, kind string) string {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return kind
+	}
+	return schema.GroupKind{Group: gv.Group, Kind: kind}.String()
+}
+
+// recordCapturedKind remembers that a kind is part of the backup set.
+func (cb *ClusterBackup) recordCapturedKind(groupVersion, kind string) {
+	if cb.capturedKinds == nil {
+		cb.capturedKinds = make(map[string]bool)
+	}
+	cb.capturedKinds[groupKind(groupVersion, kind)] = true
+}
+
+// controllerOwner returns the controller owner reference of an object, or
+// nil.
+func controllerOwner(item *unstructured.Unstructured) *metav1.OwnerReference {
+	for _, owner := range item.GetOwnerReferences() {
+		if owner.Controller != nil && *owner.Controller {
+			owner := owner
+			return &owner
+		}
+	}
+	return nil
+}
+
+// skipOwnedResource applies the owned-resources mode to an object.
+func (cb *ClusterBackup) skipOwnedResource(item *unstructured.Unstructured) bool {
+	owner := controllerOwner(item)
+	if owner == nil {
+		return false
+	}
+	switch cb.backupConfig.OwnedResources {
+	case ownedResourcesInclude:
+		return false
+	case ownedResourcesUncaptured:
+		return cb.capturedKinds[groupKind(owner.APIVersion, owner.Kind)]
+	default:
+		return true
+	}
+}
+
+// ownerLinks returns the owner references of an object for the run
+// manifest.
+func (cb *ClusterBackup) ownerLinks(item *unstructured.Unstructured) []OwnerLink {
+	references := item.GetOwnerReferences()
+	if len(references) == 0 {
+		return nil
+	}
+	links := make([]OwnerLink, 0, len(references))
+	for _, owner := range references {
+		links = append(links, OwnerLink{
+			APIVersion: owner.APIVersion,
+			Kind:       owner.Kind,
+			Name:       owner.Name,
+			UID:        string(owner.UID),
+			Controller: owner.Controller != nil && *owner.Controller,
+			Captured:   cb.capturedKinds[groupKind(owner.APIVersion, owner.Kind)],
+		})
+	}
+	return links
+}
+
+// ownerIndex holds the objects of a restore, to find children whose
+// controller owner is restored as well and will recreate them.
+type ownerIndex map[string]bool
+
+func ownerIndexKey(namespace, groupKind, name string) string {
+	return namespace + "/" + groupKind + "/" + name
+}
+
+// add records a restored object.
+func (index ownerIndex) add(object map[string]interface{}) {
+	item := unstructured.Unstructured{Object: object}
+	index[ownerIndexKey(item.GetNamespace(), groupKind(item.GetAPIVersion(), item.GetKind()), item.GetName())] = true
+}
+
+// ownerRestored reports whether the controller owner of an object is part of
+// the restore. Owners are in the object's namespace or cluster-scoped.
+func (index ownerIndex) ownerRestored(object map[string]interface{}) bool {
+	item := &unstructured.Unstructured{Object: object}
+	owner := controllerOwner(item)
+	if owner == nil {
+		return false
+	}
+	kind := groupKind(owner.APIVersion, owner.Kind)
+	return index[ownerIndexKey(item.GetNamespace(), kind, owner.Name)] || index[ownerIndexKey("", kind, owner.Name)]
+}
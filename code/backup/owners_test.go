@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestManifestOwnerGraph(t *testing.T) {
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "app", "uid": "deployment-uid"},
+	}}
+	replicaSet := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "ReplicaSet",
+		"metadata": map[string]interface{}{
+			"name":      "web-5d8f",
+			"namespace": "app",
+			"uid":       "replicaset-uid",
+			"ownerReferences": []interface{}{map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"name":       "web",
+				"uid":        "deployment-uid",
+				"controller": true,
+			}},
+		},
+	}}
+
+	cb := &ClusterBackup{config: &Config{ClusterName: "c1"}, backupConfig: &BackupConfig{}}
+	cb.recordCapturedKind("apps/v1", "Deployment")
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1"}
+
+	entries := make(map[string]ManifestEntry)
+	for _, item := range []*unstructured.Unstructured{deployment, replicaSet} {
+		// Upload order: the object is cleaned before its entry is recorded
+		cb.cleanResource(item)
+		userMetadata, _ := cb.backupObjectMetadata("app", gvr, item, nil)
+		entries[item.GetKind()] = cb.manifestEntry(item.GetName()+".yaml", "app", gvr, item, userMetadata, 0)
+	}
+
+	owner, child := entries["Deployment"], entries["ReplicaSet"]
+	if owner.UID != "deployment-uid" {
+		t.Errorf("owner UID = %q, want deployment-uid", owner.UID)
+	}
+	if child.UID != "replicaset-uid" {
+		t.Errorf("child UID = %q, want replicaset-uid", child.UID)
+	}
+	if len(child.Owners) != 1 {
+		t.Fatalf("child has %d owners, want 1", len(child.Owners))
+	}
+	link := child.Owners[0]
+	if link.UID != owner.UID {
+		t.Errorf("owner link UID = %q, does not match owner entry UID %q", link.UID, owner.UID)
+	}
+	if !link.Controller || !link.Captured {
+		t.Errorf("owner link = %+v, want controller and captured", link)
+	}
+}
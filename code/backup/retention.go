@@ -0,0 +1,403 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	retentionPolicyAge = "age"
+	retentionPolicyGFS = "gfs"
+)
+
+// GFSPolicy is a grandfather-father-son retention schedule. Each count keeps
+// the newest snapshot of that many distinct periods.
+type GFSPolicy struct {
+	KeepLast    int
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+	KeepYearly  int
+}
+
+// Snapshot is one backup run stored under the snapshot layout. Complete is
+// set when the run record says every selected namespace was stored; runs
+// that are in progress, crashed or partially failed are not complete.
+type Snapshot struct {
+	RunID    string    `json:"run_id"`
+	Time     time.Time `json:"time"`
+	Prefix   string    `json:"-"`
+	Complete bool      `json:"complete"`
+}
+
+// newestCompleteSnapshot returns the newest complete snapshot taken at or
+// before asOf, any time if asOf is zero. Snapshots must be sorted newest
+// first.
+func newestCompleteSnapshot(snapshots []Snapshot, asOf time.Time) (Snapshot, bool) {
+	for _, snapshot := range snapshots {
+		if snapshot.Complete && (asOf.IsZero() || !snapshot.Time.After(asOf)) {
+			return snapshot, true
+		}
+	}
+	return Snapshot{}, false
+}
+
+// RetentionDecision records whether a snapshot survives and why.
+type RetentionDecision struct {
+	Snapshot
+	Keep    bool     `json:"keep"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// objectKey returns the storage key of a backed up resource. In snapshot mode
+// every run writes to its own prefix so runs can be retained independently.
+func (cb *ClusterBackup) objectKey(namespace, resourceType, name string) string {
+	if cb.backupConfig.SnapshotMode {
+		return fmt.Sprintf("clusterbackup/%s/snapshots/%s/%s/%s/%s.yaml",
+			cb.config.ClusterName, cb.runID, namespace, resourceType, name)
+	}
+	return fmt.Sprintf("clusterbackup/%s/%s/%s/%s.yaml",
+		cb.config.ClusterName, namespace, resourceType, name)
+}
+
+func (cb *ClusterBackup) snapshotsPrefix() string {
+	return fmt.Sprintf("clusterbackup/%s/snapshots/", cb.config.ClusterName)
+}
+
+// listSnapshots returns the snapshots of this cluster, newest first.
+func (cb *ClusterBackup) listSnapshots() ([]Snapshot, error) {
+	prefix := cb.snapshotsPrefix()
+	var snapshots []Snapshot
+
+	records, err := cb.listRunRecords()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list runs: %v", err)
+	}
+	complete := make(map[string]bool, len(records))
+	for _, record := range records {
+		complete[record.RunID] = record.complete()
+	}
+
+	for object := range cb.minioClient.ListObjects(cb.ctx, cb.config.MinIOBucket, minio.ListObjectsOptions{
+		Prefix: prefix,
+	}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		if !strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+
+		runID := strings.TrimSuffix(strings.TrimPrefix(object.Key, prefix), "/")
+		runTime, err := time.Parse("20060102T150405Z", runID)
+		if err != nil {
+			cb.logger.Warn("snapshot_unrecognized", "Ignoring snapshot prefix with unrecognized run ID", map[string]interface{}{
+				"prefix": object.Key,
+			})
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{RunID: runID, Time: runTime, Prefix: object.Key, Complete: complete[runID]})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.After(snapshots[j].Time)
+	})
+	return snapshots, nil
+}
+
+// applyGFS decides which snapshots to keep. Snapshots must be sorted newest
+// first. The newest snapshot is always kept so a misconfigured policy can
+// never remove every backup.
+func applyGFS(snapshots []Snapshot, policy GFSPolicy) []RetentionDecision {
+	decisions := make([]RetentionDecision, len(snapshots))
+	for i, snapshot := range snapshots {
+		decisions[i] = RetentionDecision{Snapshot: snapshot}
+	}
+	if len(decisions) == 0 {
+		return decisions
+	}
+
+	keep := func(i int, reason string) {
+		decisions[i].Keep = true
+		decisions[i].Reasons = append(decisions[i].Reasons, reason)
+	}
+
+	keep(0, "latest")
+	for i := 0; i < len(decisions) && i < policy.KeepLast; i++ {
+		keep(i, fmt.Sprintf("last %d", policy.KeepLast))
+	}
+
+	buckets := []struct {
+		name  string
+		count int
+		key   func(time.Time) string
+	}{
+		{"daily", policy.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", policy.KeepWeekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{"monthly", policy.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", policy.KeepYearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+
+	for _, bucket := range buckets {
+		seen := make(map[string]bool)
+		for i := range decisions {
+			if len(seen) >= bucket.count {
+				break
+			}
+			period := bucket.key(decisions[i].Time.UTC())
+			if seen[period] {
+				continue
+			}
+			seen[period] = true
+			keep(i, fmt.Sprintf("%s %s", bucket.name, period))
+		}
+	}
+
+	return decisions
+}
+
+// incompleteDecisions keeps incomplete snapshots newer than the newest
+// complete one, they may still be written or hold the latest state of
+// namespaces that failed before. Older ones are superseded and deleted.
+func incompleteDecisions(incomplete, complete []Snapshot) []RetentionDecision {
+	decisions := make([]RetentionDecision, 0, len(incomplete))
+	for _, snapshot := range incomplete {
+		decision := RetentionDecision{Snapshot: snapshot, Reasons: []string{"incomplete"}}
+		if len(complete) == 0 || snapshot.Time.After(complete[0].Time) {
+			decision.Keep = true
+			decision.Reasons = append(decision.Reasons, "newer than latest complete")
+		}
+		decisions = append(decisions, decision)
+	}
+	return decisions
+}
+
+// performGFSCleanup applies the GFS policy to the snapshots of this cluster
+// and removes every snapshot that is not kept.
+func (cb *ClusterBackup) performGFSCleanup() error {
+	startTime := time.Now()
+	snapshots, err := cb.listSnapshots()
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %v", err)
+	}
+
+	pins, err := cb.pinnedRuns()
+	if err != nil {
+		return fmt.Errorf("failed to read pinned runs: %v", err)
+	}
+
+	// Pinned snapshots are outside the policy: they neither count towards
+	// the GFS slots nor get deleted. Incomplete snapshots do not fill a slot
+	// either, so they never push out an older complete one.
+	var complete, incomplete []Snapshot
+	var pinned []Snapshot
+	for _, snapshot := range snapshots {
+		switch _, ok := pins[snapshot.RunID]; {
+		case ok:
+			pinned = append(pinned, snapshot)
+		case snapshot.Complete:
+			complete = append(complete, snapshot)
+		default:
+			incomplete = append(incomplete, snapshot)
+		}
+	}
+
+	decisions := append(applyGFS(complete, cb.backupConfig.GFS), incompleteDecisions(incomplete, complete)...)
+
+	var kept, expired []RetentionDecision
+	for _, decision := range decisions {
+		if decision.Keep {
+			kept = append(kept, decision)
+		} else {
+			expired = append(expired, decision)
+		}
+	}
+
+	cb.logger.Info("retention_report", "GFS retention evaluated", map[string]interface{}{
+		"policy":    cb.backupConfig.GFS,
+		"dry_run":   cb.backupConfig.CleanupDryRun,
+		"snapshots": len(snapshots),
+		"pinned":    pinned,
+		"kept":      kept,
+		"delete":    expired,
+	})
+
+	if cb.backupConfig.CleanupDryRun {
+		return nil
+	}
+
+	var removedObjects int
+	var removedSize int64
+	var failed []string
+	// Objects under retention or legal hold cannot be deleted, so they are
+	// skipped like in age-based cleanup
+	objectLock, err := cb.bucketHasObjectLock()
+	if err != nil {
+		cb.logger.Warn("cleanup_object_lock_check", "Could not determine bucket object lock status", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	for _, decision := range expired {
+		count, size, err := cb.removePrefix(decision.Prefix, objectLock)
+		removedObjects += count
+		removedSize += size
+		if err != nil {
+			failed = append(failed, decision.RunID)
+			cb.logger.Error("retention_delete_failed", "Failed to delete expired snapshot", map[string]interface{}{
+				"run_id": decision.RunID,
+				"error":  err.Error(),
+			})
+			continue
+		}
+		cb.logger.Info("retention_snapshot_deleted", "Deleted expired snapshot", map[string]interface{}{
+			"run_id":          decision.RunID,
+			"removed_objects": count,
+			"removed_bytes":   size,
+		})
+	}
+
+	cb.cleanupStats = cleanupStats{CleanedFiles: removedObjects, CleanedBytes: removedSize}
+	cb.logger.Info("cleanup_complete", "GFS cleanup completed", map[string]interface{}{
+		"snapshots_deleted":  len(expired) - len(failed),
+		"snapshots_kept":     len(kept),
+		"snapshots_pinned":   len(pinned),
+		"cleaned_files":      removedObjects,
+		"cleaned_size_bytes": removedSize,
+		"duration_ms":        time.Since(startTime).Milliseconds(),
+	})
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to delete %d snapshots: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// removeBatchSize matches the S3 DeleteObjects limit of 1000 keys per request.
+const removeBatchSize = 1000
+
+// clusterPrefix is the key prefix of every object written for this cluster.
+func (cb *ClusterBackup) clusterPrefix() string {
+	return fmt.Sprintf("clusterbackup/%s/", cb.config.ClusterName)
+}
+
+// removePrefix deletes every object below prefix. With objectLock, objects
+// still under retention or legal hold are skipped.
+func (cb *ClusterBackup) removePrefix(prefix string, objectLock bool) (int, int64, error) {
+	now := time.Now()
+	var objects []minio.ObjectInfo
+	retained := 0
+	for object := range cb.minioClient.ListObjects(cb.ctx, cb.config.MinIOBucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if object.Err != nil {
+			return 0, 0, object.Err
+		}
+		if objectLock {
+			reason, err := cb.objectRetentionReason(object.Key, now)
+			if err != nil {
+				cb.logger.Warn("cleanup_retention_check", "Failed to read object retention", map[string]interface{}{
+					"object_key": object.Key,
+					"error":      err.Error(),
+				})
+			}
+			if reason != "" {
+				retained++
+				continue
+			}
+		}
+		objects = append(objects, object)
+	}
+	if retained > 0 {
+		cb.logger.Info("cleanup_skip_retained", "Skipping objects protected by object lock", map[string]interface{}{
+			"prefix":   prefix,
+			"retained": retained,
+		})
+	}
+
+	count, size, errors := cb.removeObjects(objects)
+	if len(errors) > 0 {
+		return count, size, fmt.Errorf("%d objects could not be removed, first: %s", len(errors), errors[0])
+	}
+	return count, size, nil
+}
+
+// removeObjects deletes objects with batched DeleteObjects requests, running
+// up to CleanupConcurrency batches in parallel. It returns the number and
+// total size of removed objects and one message per failed deletion.
+func (cb *ClusterBackup) removeObjects(objects []minio.ObjectInfo) (int, int64, []string) {
+	workers := cb.backupConfig.CleanupConcurrency
+	if workers <= 0 {
+		workers = 1
+	}
+
+	batches := make(chan []minio.ObjectInfo)
+	go func() {
+		defer close(batches)
+		for start := 0; start < len(objects); start += removeBatchSize {
+			end := start + removeBatchSize
+			if end > len(objects) {
+				end = len(objects)
+			}
+			batches <- objects[start:end]
+		}
+	}()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var removed int
+	var removedSize int64
+	var errors []string
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				sizes := make(map[string]int64, len(batch))
+				objectsCh := make(chan minio.ObjectInfo, len(batch))
+				for _, object := range batch {
+					sizes[object.Key] = object.Size
+					objectsCh <- object
+				}
+				close(objectsCh)
+
+				failed := make(map[string]bool)
+				for result := range cb.minioClient.RemoveObjects(cb.ctx, cb.config.MinIOBucket, objectsCh, minio.RemoveObjectsOptions{}) {
+					if result.Err == nil {
+						continue
+					}
+					failed[result.ObjectName] = true
+					mu.Lock()
+					errors = append(errors, fmt.Sprintf("remove %s: %v", result.ObjectName, result.Err))
+					mu.Unlock()
+					cb.logger.Error("cleanup_remove_error", "Failed to remove old backup file", map[string]interface{}{
+						"object_key": result.ObjectName,
+						"error":      result.Err.Error(),
+					})
+				}
+
+				mu.Lock()
+				for key, size := range sizes {
+					if !failed[key] {
+						removed++
+						removedSize += size
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return removed, removedSize, errors
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// RetentionRule overrides RetentionDays for the objects it matches. Empty
+// fields match everything; the most specific matching rule wins.
+type RetentionRule struct {
+	Name          string   `yaml:"name"`
+	Namespaces    []string `yaml:"namespaces"`    // glob patterns, e.g. "prod-*"
+	Groups        []string `yaml:"groups"`        // API groups, "core" for the core group
+	Kinds         []string `yaml:"kinds"`         // kinds, e.g. "Secret"
+	LabelSelector string   `yaml:"labelSelector"` // label selector on the backed up object
+	Days          int      `yaml:"days"`
+
+	selector labels.Selector
+}
+
+// objectAttributes describes a stored object for retention rule matching.
+type objectAttributes struct {
+	Namespace string
+	Group     string
+	Kind      string
+	Labels    labels.Set
+}
+
+func parseRetentionRules(data string) ([]RetentionRule, error) {
+	var rules []RetentionRule
+	if err := yaml.Unmarshal([]byte(data), &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse retention-rules: %v", err)
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if rule.Days <= 0 {
+			return nil, fmt.Errorf("retention rule %s: days must be positive", rule.Name)
+		}
+		for _, pattern := range rule.Namespaces {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("retention rule %s: invalid namespace pattern %q", rule.Name, pattern)
+			}
+		}
+		if rule.LabelSelector != "" {
+			selector, err := labels.Parse(rule.LabelSelector)
+			if err != nil {
+				return nil, fmt.Errorf("retention rule %s: invalid label selector: %v", rule.Name, err)
+			}
+			rule.selector = selector
+		}
+	}
+
+	return rules, nil
+}
+
+// needsObjectMetadata reports whether the rule matches on attributes that are
+// only available from the object's user metadata.
+func (r *RetentionRule) needsObjectMetadata() bool {
+	return len(r.Groups) > 0 || len(r.Kinds) > 0 || r.selector != nil
+}
+
+// specificity ranks rules: each constrained dimension counts once, and an
+// exact namespace name ranks above a wildcard pattern.
+func (r *RetentionRule) specificity(namespace string) int {
+	score := 0
+	if len(r.Namespaces) > 0 {
+		score += 2
+		for _, pattern := range r.Namespaces {
+			if pattern == namespace {
+				score++
+				break
+			}
+		}
+	}
+	if len(r.Groups) > 0 {
+		score += 2
+	}
+	if len(r.Kinds) > 0 {
+		score += 2
+	}
+	if r.selector != nil {
+		score += 2
+	}
+	return score
+}
+
+func (r *RetentionRule) matches(attrs objectAttributes) bool {
+	if len(r.Namespaces) > 0 && !matchesAnyPattern(attrs.Namespace, r.Namespaces) {
+		return false
+	}
+	if len(r.Groups) > 0 && !containsFold(r.Groups, attrs.Group) {
+		return false
+	}
+	if len(r.Kinds) > 0 && !containsFold(r.Kinds, attrs.Kind) {
+		return false
+	}
+	if r.selector != nil && !r.selector.Matches(attrs.Labels) {
+		return false
+	}
+	return true
+}
+
+func matchesAnyPattern(value string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// retentionFor returns the retention period of an object and the name of the
+// rule that set it. Ties between equally specific rules go to the first one.
+func (cb *ClusterBackup) retentionFor(attrs objectAttributes) (int, string) {
+	days, ruleName := cb.backupConfig.RetentionDays, "retention-days"
+	best := -1
+	for i := range cb.backupConfig.RetentionRules {
+		rule := &cb.backupConfig.RetentionRules[i]
+		if !rule.matches(attrs) {
+			continue
+		}
+		if score := rule.specificity(attrs.Namespace); score > best {
+			best = score
+			days, ruleName = rule.Days, rule.Name
+		}
+	}
+	return days, ruleName
+}
+
+func (cb *ClusterBackup) retentionRulesNeedMetadata() bool {
+	for i := range cb.backupConfig.RetentionRules {
+		if cb.backupConfig.RetentionRules[i].needsObjectMetadata() {
+			return true
+		}
+	}
+	return false
+}
+
+// objectCutoff returns the time before which the object is expired together
+// with the name of the retention rule applied to it.
+func (cb *ClusterBackup) objectCutoff(object minio.ObjectInfo, now time.Time) (time.Time, string) {
+	if len(cb.backupConfig.RetentionRules) == 0 {
+		return now.AddDate(0, 0, -cb.backupConfig.RetentionDays), "retention-days"
+	}
+
+	attrs := objectAttributes{Namespace: cb.namespaceFromKey(object.Key)}
+	if cb.retentionRulesNeedMetadata() {
+		metadata := object.UserMetadata
+		if len(metadata) == 0 {
+			info, err := cb.minioClient.StatObject(cb.ctx, cb.config.MinIOBucket, object.Key, cb.statObjectOptions())
+			if err != nil {
+				cb.logger.Warn("cleanup_metadata_failed", "Failed to read object metadata for retention rules", map[string]interface{}{
+					"object_key": object.Key,
+					"error":      err.Error(),
+				})
+			}
+			metadata = info.UserMetadata
+		}
+		attrs.Group = userMetadataValue(metadata, metaGroup)
+		attrs.Kind = userMetadataValue(metadata, metaKind)
+		if parsed, err := labels.ConvertSelectorToLabelsMap(userMetadataValue(metadata, metaLabels)); err == nil {
+			attrs.Labels = parsed
+		}
+	}
+
+	days, rule := cb.retentionFor(attrs)
+	return now.AddDate(0, 0, -days), rule
+}
+
+// namespaceFromKey extracts the namespace from a flat or snapshot object key.
+func (cb *ClusterBackup) namespaceFromKey(key string) string {
+	rest := strings.TrimPrefix(key, cb.clusterPrefix())
+	if strings.HasPrefix(rest, "snapshots/") {
+		parts := strings.SplitN(rest, "/", 3)
+		if len(parts) < 3 {
+			return ""
+		}
+		rest = parts[2]
+	}
+	return strings.SplitN(rest, "/", 2)[0]
+}
+
+// userMetadataValue looks up a user metadata key regardless of whether the
+// listing returned it with or without the X-Amz-Meta- prefix.
+func userMetadataValue(metadata map[string]string, key string) string {
+	for k, v := range metadata {
+		k = strings.TrimPrefix(strings.ToLower(k), "x-amz-meta-")
+		if k == key {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func TestRetentionRuleSpecificity(t *testing.T) {
+	rules, err := parseRetentionRules(`
+- name: all
+  days: 1
+- name: prod-glob
+  namespaces: ["prod-*"]
+  days: 2
+- name: prod-exact
+  namespaces: ["prod-db", "prod-*"]
+  days: 3
+- name: secrets
+  kinds: ["Secret"]
+  days: 4
+- name: prod-secrets
+  namespaces: ["prod-*"]
+  kinds: ["Secret"]
+  days: 5
+- name: labelled
+  groups: ["apps"]
+  labelSelector: tier=db
+  days: 6
+`)
+	if err != nil {
+		t.Fatalf("parseRetentionRules: %v", err)
+	}
+
+	tests := []struct {
+		rule      string
+		namespace string
+		want      int
+	}{
+		{"all", "prod-db", 0},
+		{"prod-glob", "prod-db", 2},
+		{"prod-exact", "prod-db", 3},
+		{"prod-exact", "prod-web", 2},
+		{"secrets", "prod-db", 2},
+		{"prod-secrets", "prod-db", 4},
+		{"labelled", "prod-db", 4},
+	}
+
+	byName := make(map[string]*RetentionRule)
+	for i := range rules {
+		byName[rules[i].Name] = &rules[i]
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule+"/"+tt.namespace, func(t *testing.T) {
+			if got := byName[tt.rule].specificity(tt.namespace); got != tt.want {
+				t.Errorf("specificity = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestObjectCutoff(t *testing.T) {
+	rules, err := parseRetentionRules(`
+- name: prod
+  namespaces: ["prod-*"]
+  days: 30
+- name: prod-db
+  namespaces: ["prod-db"]
+  days: 90
+- name: secrets
+  kinds: ["Secret"]
+  days: 7
+- name: prod-secrets
+  namespaces: ["prod-*"]
+  kinds: ["secret"]
+  days: 14
+- name: tier-db
+  labelSelector: tier=db
+  days: 60
+`)
+	if err != nil {
+		t.Fatalf("parseRetentionRules: %v", err)
+	}
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		rules    []RetentionRule
+		key      string
+		metadata map[string]string
+		wantDays int
+		wantRule string
+	}{
+		{
+			name:     "no rules uses retention-days",
+			key:      "clusterbackup/c1/prod-db/secrets/s.yaml",
+			wantDays: 10,
+			wantRule: "retention-days",
+		},
+		{
+			name:     "unmatched object uses retention-days",
+			rules:    rules,
+			key:      "clusterbackup/c1/dev/configmaps/cm.yaml",
+			metadata: map[string]string{"X-Amz-Meta-Kind": "ConfigMap"},
+			wantDays: 10,
+			wantRule: "retention-days",
+		},
+		{
+			name:     "namespace glob",
+			rules:    rules,
+			key:      "clusterbackup/c1/prod-web/configmaps/cm.yaml",
+			metadata: map[string]string{"X-Amz-Meta-Kind": "ConfigMap"},
+			wantDays: 30,
+			wantRule: "prod",
+		},
+		{
+			name:     "exact namespace beats glob",
+			rules:    rules,
+			key:      "clusterbackup/c1/snapshots/20240330-120000/prod-db/configmaps/cm.yaml",
+			metadata: map[string]string{"X-Amz-Meta-Kind": "ConfigMap"},
+			wantDays: 90,
+			wantRule: "prod-db",
+		},
+		{
+			name:     "namespace and kind beat exact namespace",
+			rules:    rules,
+			key:      "clusterbackup/c1/prod-db/secrets/s.yaml",
+			metadata: map[string]string{"X-Amz-Meta-Kind": "Secret"},
+			wantDays: 14,
+			wantRule: "prod-secrets",
+		},
+		{
+			name:     "kind outside matched namespaces",
+			rules:    rules,
+			key:      "clusterbackup/c1/dev/secrets/s.yaml",
+			metadata: map[string]string{"kind": "Secret"},
+			wantDays: 7,
+			wantRule: "secrets",
+		},
+		{
+			name:     "label selector",
+			rules:    rules,
+			key:      "clusterbackup/c1/dev/statefulsets/db.yaml",
+			metadata: map[string]string{"X-Amz-Meta-Kind": "StatefulSet", "X-Amz-Meta-Labels": "app=db,tier=db"},
+			wantDays: 60,
+			wantRule: "tier-db",
+		},
+		{
+			name:     "equal specificity goes to the first rule",
+			rules:    rules,
+			key:      "clusterbackup/c1/dev/secrets/db.yaml",
+			metadata: map[string]string{"X-Amz-Meta-Kind": "Secret", "X-Amz-Meta-Labels": "tier=db"},
+			wantDays: 7,
+			wantRule: "secrets",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := &ClusterBackup{
+				config:       &Config{ClusterName: "c1"},
+				backupConfig: &BackupConfig{RetentionDays: 10, RetentionRules: tt.rules},
+			}
+			cutoff, rule := cb.objectCutoff(minio.ObjectInfo{Key: tt.key, UserMetadata: tt.metadata}, now)
+			if want := now.AddDate(0, 0, -tt.wantDays); !cutoff.Equal(want) {
+				t.Errorf("cutoff = %s, want %s", cutoff, want)
+			}
+			if rule != tt.wantRule {
+				t.Errorf("rule = %s, want %s", rule, tt.wantRule)
+			}
+		})
+	}
+}
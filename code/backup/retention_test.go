@@ -0,0 +1,204 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// snapshotsAt builds snapshots from RFC 3339 times, which must be given
+// newest first like listSnapshots returns them.
+func snapshotsAt(t *testing.T, times ...string) []Snapshot {
+	t.Helper()
+	snapshots := make([]Snapshot, len(times))
+	for i, value := range times {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatalf("invalid time %s: %v", value, err)
+		}
+		snapshots[i] = Snapshot{RunID: value, Time: parsed, Complete: true}
+	}
+	return snapshots
+}
+
+func TestApplyGFS(t *testing.T) {
+	tests := []struct {
+		name    string
+		times   []string
+		policy  GFSPolicy
+		reasons [][]string // per snapshot, nil if deleted
+	}{
+		{
+			name:    "no snapshots",
+			policy:  GFSPolicy{KeepLast: 3},
+			reasons: [][]string{},
+		},
+		{
+			name:    "empty policy keeps the latest",
+			times:   []string{"2024-03-02T10:00:00Z", "2024-03-01T10:00:00Z"},
+			reasons: [][]string{{"latest"}, nil},
+		},
+		{
+			name:   "keep last",
+			times:  []string{"2024-03-03T10:00:00Z", "2024-03-02T10:00:00Z", "2024-03-01T10:00:00Z"},
+			policy: GFSPolicy{KeepLast: 2},
+			reasons: [][]string{
+				{"latest", "last 2"},
+				{"last 2"},
+				nil,
+			},
+		},
+		{
+			name: "daily keeps the newest run of each day",
+			times: []string{
+				"2024-03-03T18:00:00Z", "2024-03-03T06:00:00Z",
+				"2024-03-02T18:00:00Z", "2024-03-02T06:00:00Z",
+				"2024-03-01T18:00:00Z",
+			},
+			policy: GFSPolicy{KeepDaily: 2},
+			reasons: [][]string{
+				{"latest", "daily 2024-03-03"},
+				nil,
+				{"daily 2024-03-02"},
+				nil,
+				nil,
+			},
+		},
+		{
+			name: "weekly uses ISO weeks across the year boundary",
+			times: []string{
+				"2024-12-31T10:00:00Z", // Tuesday of 2025-W01
+				"2024-12-30T10:00:00Z", // Monday of 2025-W01
+				"2024-12-29T10:00:00Z", // Sunday of 2024-W52
+			},
+			policy: GFSPolicy{KeepWeekly: 2},
+			reasons: [][]string{
+				{"latest", "weekly 2025-W01"},
+				nil,
+				{"weekly 2024-W52"},
+			},
+		},
+		{
+			name: "monthly and yearly",
+			times: []string{
+				"2024-02-10T10:00:00Z",
+				"2024-01-20T10:00:00Z",
+				"2024-01-05T10:00:00Z",
+				"2023-12-15T10:00:00Z",
+				"2022-06-01T10:00:00Z",
+			},
+			policy: GFSPolicy{KeepMonthly: 2, KeepYearly: 3},
+			reasons: [][]string{
+				{"latest", "monthly 2024-02", "yearly 2024"},
+				{"monthly 2024-01"},
+				nil,
+				{"yearly 2023"},
+				{"yearly 2022"},
+			},
+		},
+		{
+			name: "buckets use UTC",
+			times: []string{
+				"2024-03-02T01:00:00+02:00", // 2024-03-01 in UTC
+				"2024-03-01T12:00:00Z",
+			},
+			policy: GFSPolicy{KeepDaily: 2},
+			reasons: [][]string{
+				{"latest", "daily 2024-03-01"},
+				nil,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decisions := applyGFS(snapshotsAt(t, tt.times...), tt.policy)
+			if len(decisions) != len(tt.reasons) {
+				t.Fatalf("got %d decisions, want %d", len(decisions), len(tt.reasons))
+			}
+			for i, decision := range decisions {
+				want := tt.reasons[i]
+				if decision.Keep != (want != nil) {
+					t.Errorf("%s: keep = %v, want %v", decision.RunID, decision.Keep, want != nil)
+				}
+				if want != nil && !reflect.DeepEqual(decision.Reasons, want) {
+					t.Errorf("%s: reasons = %q, want %q", decision.RunID, decision.Reasons, want)
+				}
+			}
+		})
+	}
+}
+
+func TestIncompleteDecisions(t *testing.T) {
+	tests := []struct {
+		name       string
+		incomplete []string
+		complete   []string
+		keep       []bool
+	}{
+		{
+			name:       "no complete snapshot keeps all",
+			incomplete: []string{"2024-03-02T10:00:00Z", "2024-03-01T10:00:00Z"},
+			keep:       []bool{true, true},
+		},
+		{
+			name:       "older than the latest complete are deleted",
+			incomplete: []string{"2024-03-03T10:00:00Z", "2024-03-01T10:00:00Z"},
+			complete:   []string{"2024-03-02T10:00:00Z", "2024-02-28T10:00:00Z"},
+			keep:       []bool{true, false},
+		},
+		{
+			name:       "same time as the latest complete is deleted",
+			incomplete: []string{"2024-03-02T10:00:00Z"},
+			complete:   []string{"2024-03-02T10:00:00Z"},
+			keep:       []bool{false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decisions := incompleteDecisions(snapshotsAt(t, tt.incomplete...), snapshotsAt(t, tt.complete...))
+			if len(decisions) != len(tt.keep) {
+				t.Fatalf("got %d decisions, want %d", len(decisions), len(tt.keep))
+			}
+			for i, decision := range decisions {
+				if decision.Keep != tt.keep[i] {
+					t.Errorf("%s: keep = %v, want %v", decision.RunID, decision.Keep, tt.keep[i])
+				}
+				if decision.Reasons[0] != "incomplete" {
+					t.Errorf("%s: reasons = %q, want incomplete first", decision.RunID, decision.Reasons)
+				}
+			}
+		})
+	}
+}
+
+func TestNewestCompleteSnapshot(t *testing.T) {
+	snapshots := snapshotsAt(t, "2024-03-04T10:00:00Z", "2024-03-03T10:00:00Z", "2024-03-02T10:00:00Z", "2024-03-01T10:00:00Z")
+	snapshots[0].Complete = false // in progress
+	snapshots[2].Complete = false // partial
+
+	tests := []struct {
+		name string
+		asOf string
+		want string
+	}{
+		{"latest skips incomplete", "", "2024-03-03T10:00:00Z"},
+		{"at a complete snapshot", "2024-03-03T10:00:00Z", "2024-03-03T10:00:00Z"},
+		{"before a complete snapshot skips partial", "2024-03-03T09:00:00Z", "2024-03-01T10:00:00Z"},
+		{"before every snapshot", "2024-02-28T10:00:00Z", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var asOf time.Time
+			if tt.asOf != "" {
+				asOf = snapshotsAt(t, tt.asOf)[0].Time
+			}
+			snapshot, ok := newestCompleteSnapshot(snapshots, asOf)
+			if ok != (tt.want != "") || snapshot.RunID != tt.want {
+				t.Errorf("newestCompleteSnapshot = %q, %v, want %q", snapshot.RunID, ok, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// storedRef points at one stored version of an object.
+type storedRef struct {
+	Key       string
+	VersionID string
+	ETag      string
+}
+
+// storedTree is the content of one backup, keyed by
+// {namespace}/{resource-type}/{name}.yaml.
+type storedTree struct {
+	Label   string
+	Objects map[string]storedRef
+}
+
+// FieldChange is a difference at one path of a YAML document. A missing
+// From or To means the field was added or removed.
+type FieldChange struct {
+	Path string      `json:"path"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// ObjectChange is one added, removed or modified object between two backups.
+type ObjectChange struct {
+	Change    string        `json:"change"`
+	Namespace string        `json:"namespace"`
+	Resource  string        `json:"resource"`
+	Kind      string        `json:"kind,omitempty"`
+	Name      string        `json:"name"`
+	Fields    []FieldChange `json:"fields,omitempty"`
+}
+
+// RunDiffReport is the structured diff between two stored backups.
+type RunDiffReport struct {
+	From     string         `json:"from"`
+	To       string         `json:"to"`
+	Added    int            `json:"added"`
+	Removed  int            `json:"removed"`
+	Modified int            `json:"modified"`
+	Changes  []ObjectChange `json:"changes"`
+}
+
+// forCluster returns a view of the backup client operating on the objects of
+// another cluster in the same bucket.
+func (cb *ClusterBackup) forCluster(name string) *ClusterBackup {
+	if name == "" || name == cb.config.ClusterName {
+		return cb
+	}
+	config := *cb.config
+	config.ClusterName = name
+	return &ClusterBackup{
+		config:          &config,
+		backupConfig:    cb.backupConfig,
+		minioClient:     cb.minioClient,
+		sse:             cb.sse,
+		kubeClient:      cb.kubeClient,
+		dynamicClient:   cb.dynamicClient,
+		discoveryClient: cb.discoveryClient,
+		metrics:         cb.metrics,
+		ctx:             cb.ctx,
+		logger:          cb.logger,
+		runID:           cb.runID,
+	}
+}
+
+// resolveStoredTree resolves a backup reference of the form
+// [<cluster>/]<ref>, where ref is "latest", a snapshot run ID, a run label
+// or a timestamp. "latest" selects the newest complete snapshot, like drift
+// does, and timestamps the newest complete snapshot taken at or before them,
+// or on versioned buckets the flat layout as it was at that time. Incomplete
+// snapshots are only used when named by run ID or label.
+func (cb *ClusterBackup) resolveStoredTree(spec string) (*storedTree, error) {
+	cluster, ref := "", spec
+	if i := strings.Index(spec, "/"); i >= 0 {
+		cluster, ref = spec[:i], spec[i+1:]
+	}
+	view := cb.forCluster(cluster)
+	label := view.config.ClusterName + "/" + ref
+
+	snapshots, err := view.listSnapshots()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots of %s: %v", view.config.ClusterName, err)
+	}
+
+	fromPrefix := func(prefix, resolved string) (*storedTree, error) {
+		objects, err := view.listStoredObjects(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %v", prefix, err)
+		}
+		tree := &storedTree{Label: view.config.ClusterName + "/" + resolved, Objects: make(map[string]storedRef, len(objects))}
+		for rel, object := range objects {
+			tree.Objects[rel] = storedRef{Key: object.Key, ETag: object.ETag}
+		}
+		return tree, nil
+	}
+
+	if ref == "latest" || ref == "" {
+		if len(snapshots) == 0 {
+			return fromPrefix(view.clusterPrefix(), "latest")
+		}
+		if snapshot, ok := newestCompleteSnapshot(snapshots, time.Time{}); ok {
+			return fromPrefix(snapshot.Prefix, snapshot.RunID)
+		}
+		return nil, fmt.Errorf("no complete snapshots stored for cluster %s", view.config.ClusterName)
+	}
+
+	for _, snapshot := range snapshots {
+		if snapshot.RunID == ref {
+			return fromPrefix(snapshot.Prefix, snapshot.RunID)
+		}
+	}
+
+	if record, err := view.resolveRun(ref); err == nil {
+		for _, snapshot := range snapshots {
+			if snapshot.RunID == record.RunID {
+				return fromPrefix(snapshot.Prefix, snapshot.RunID)
+			}
+		}
+		if records, err := view.listRunRecords(); err == nil && len(records) > 0 && records[0].RunID == record.RunID && !record.SnapshotMode {
+			return fromPrefix(view.clusterPrefix(), record.RunID)
+		}
+		return nil, fmt.Errorf("run %s of %s is no longer stored", record.RunID, view.config.ClusterName)
+	}
+
+	asOf, err := parseTimestamp(ref)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a snapshot, run label or timestamp", label)
+	}
+	if snapshot, ok := newestCompleteSnapshot(snapshots, asOf); ok {
+		return fromPrefix(snapshot.Prefix, snapshot.RunID)
+	}
+	if len(snapshots) > 0 {
+		return nil, fmt.Errorf("no complete snapshot of %s at or before %s", view.config.ClusterName, ref)
+	}
+
+	if err := view.requireVersioning(); err != nil {
+		return nil, err
+	}
+	versions, err := view.versionsAsOf(view.clusterPrefix(), asOf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions of %s: %v", view.config.ClusterName, err)
+	}
+	tree := &storedTree{Label: view.config.ClusterName + "@" + asOf.UTC().Format(time.RFC3339), Objects: make(map[string]storedRef, len(versions))}
+	for key, object := range versions {
+		tree.Objects[strings.TrimPrefix(key, view.clusterPrefix())] = storedRef{Key: key, VersionID: object.VersionID, ETag: object.ETag}
+	}
+	return tree, nil
+}
+
+func (cb *ClusterBackup) readStoredObject(ref storedRef) (map[string]interface{}, error) {
+	data, err := cb.readObjectVersion(ref.Key, ref.VersionID)
+	if err != nil {
+		return nil, err
+	}
+	var object map[string]interface{}
+	if err := yaml.Unmarshal(data, &object); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", ref.Key, err)
+	}
+	return object, nil
+}
+
+// diffStoredTrees compares two backups, limited to namespaces matching the
+// namespace patterns and objects whose kind or resource type is in kinds.
+func (cb *ClusterBackup) diffStoredTrees(from, to *storedTree, namespaces, kinds []string) (*RunDiffReport, error) {
+	report := &RunDiffReport{From: from.Label, To: to.Label, Changes: []ObjectChange{}}
+
+	keys := make(map[string]bool)
+	for rel := range from.Objects {
+		keys[rel] = true
+	}
+	for rel := range to.Objects {
+		keys[rel] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for rel := range keys {
+		sorted = append(sorted, rel)
+	}
+	sort.Strings(sorted)
+
+	for _, rel := range sorted {
+		namespace, resourceType, name, ok := splitRelativeKey(rel)
+		if !ok {
+			continue
+		}
+		if len(namespaces) > 0 && !matchesAnyPattern(namespace, namespaces) {
+			continue
+		}
+
+		var before, after map[string]interface{}
+		var err error
+		fromRef, inFrom := from.Objects[rel]
+		toRef, inTo := to.Objects[rel]
+		// Equal content MD5s need no download
+		if inFrom && inTo && cb.etagIsMD5() && fromRef.ETag != "" && fromRef.ETag == toRef.ETag {
+			continue
+		}
+		if inFrom {
+			if before, err = cb.readStoredObject(fromRef); err != nil {
+				return nil, err
+			}
+		}
+		if inTo {
+			if after, err = cb.readStoredObject(toRef); err != nil {
+				return nil, err
+			}
+		}
+
+		kind, _ := after["kind"].(string)
+		if kind == "" {
+			kind, _ = before["kind"].(string)
+		}
+		if len(kinds) > 0 && !containsFold(kinds, kind) && !containsFold(kinds, resourceType) {
+			continue
+		}
+
+		change := ObjectChange{Namespace: namespace, Resource: resourceType, Kind: kind, Name: name}
+		switch {
+		case !inFrom:
+			change.Change = "added"
+			report.Added++
+		case !inTo:
+			change.Change = "removed"
+			report.Removed++
+		default:
+			change.Fields = fieldChanges("", before, after)
+			if len(change.Fields) == 0 {
+				continue
+			}
+			change.Change = "modified"
+			report.Modified++
+		}
+		report.Changes = append(report.Changes, change)
+	}
+
+	return report, nil
+}
+
+// fieldChanges lists the paths at which two YAML values differ. Maps are
+// compared key by key and lists element by element; anything else is
+// reported as a whole.
+func fieldChanges(path string, from, to interface{}) []FieldChange {
+	fromMap, fromIsMap := from.(map[string]interface{})
+	toMap, toIsMap := to.(map[string]interface{})
+	if fromIsMap && toIsMap {
+		keys := make(map[string]bool)
+		for k := range fromMap {
+			keys[k] = true
+		}
+		for k := range toMap {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+
+		var changes []FieldChange
+		for _, k := range sorted {
+			child := k
+			if path != "" {
+				child = path + "." + k
+			}
+			changes = append(changes, fieldChanges(child, fromMap[k], toMap[k])...)
+		}
+		return changes
+	}
+
+	fromList, fromIsList := from.([]interface{})
+	toList, toIsList := to.([]interface{})
+	if fromIsList && toIsList {
+		var changes []FieldChange
+		for i := 0; i < len(fromList) || i < len(toList); i++ {
+			var a, b interface{}
+			if i < len(fromList) {
+				a = fromList[i]
+			}
+			if i < len(toList) {
+				b = toList[i]
+			}
+			changes = append(changes, fieldChanges(fmt.Sprintf("%s[%d]", path, i), a, b)...)
+		}
+		return changes
+	}
+
+	if reflect.DeepEqual(from, to) {
+		return nil
+	}
+	return []FieldChange{{Path: path, From: from, To: to}}
+}
+
+// writeRunDiffReport renders a run diff as text grouped by namespace, or as
+// JSON.
+func writeRunDiffReport(w io.Writer, report *RunDiffReport, format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	fmt.Fprintf(w, "Diff %s -> %s: %d added, %d removed, %d modified\n",
+		report.From, report.To, report.Added, report.Removed, report.Modified)
+	namespace := ""
+	for _, change := range report.Changes {
+		if change.Namespace != namespace {
+			namespace = change.Namespace
+			fmt.Fprintf(w, "\nnamespace %s:\n", namespace)
+		}
+		marker := map[string]string{"added": "+", "removed": "-", "modified": "~"}[change.Change]
+		fmt.Fprintf(w, "  %s %s %s (%s)\n", marker, change.Kind, change.Name, change.Resource)
+		for _, field := range change.Fields {
+			fmt.Fprintf(w, "      %s: %s -> %s\n", field.Path, inlineYAML(field.From), inlineYAML(field.To))
+		}
+	}
+	return nil
+}
+
+// inlineYAML renders a value on one line, "<none>" for a missing field.
+func inlineYAML(value interface{}) string {
+	if value == nil {
+		return "<none>"
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
+
+// runDiff compares two stored backups and prints the result.
+func (cb *ClusterBackup) runDiff(fromSpec, toSpec string, namespaces, kinds []string, format string) error {
+	from, err := cb.resolveStoredTree(fromSpec)
+	if err != nil {
+		return err
+	}
+	to, err := cb.resolveStoredTree(toSpec)
+	if err != nil {
+		return err
+	}
+	report, err := cb.diffStoredTrees(from, to, namespaces, kinds)
+	if err != nil {
+		return err
+	}
+	return writeRunDiffReport(os.Stdout, report, format)
+}
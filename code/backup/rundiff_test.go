@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFieldChanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to interface{}
+		want     []FieldChange
+	}{
+		{
+			name: "equal",
+			from: map[string]interface{}{"spec": map[string]interface{}{"replicas": 2}},
+			to:   map[string]interface{}{"spec": map[string]interface{}{"replicas": 2}},
+			want: nil,
+		},
+		{
+			name: "nested field",
+			from: map[string]interface{}{"spec": map[string]interface{}{"replicas": 2, "paused": false}},
+			to:   map[string]interface{}{"spec": map[string]interface{}{"replicas": 3, "paused": false}},
+			want: []FieldChange{{Path: "spec.replicas", From: 2, To: 3}},
+		},
+		{
+			name: "added and removed fields in key order",
+			from: map[string]interface{}{"b": "old"},
+			to:   map[string]interface{}{"a": "new"},
+			want: []FieldChange{{Path: "a", From: nil, To: "new"}, {Path: "b", From: "old", To: nil}},
+		},
+		{
+			name: "list elements by index",
+			from: map[string]interface{}{"containers": []interface{}{
+				map[string]interface{}{"image": "web:1.4"},
+			}},
+			to: map[string]interface{}{"containers": []interface{}{
+				map[string]interface{}{"image": "web:1.5"},
+				map[string]interface{}{"image": "sidecar:1"},
+			}},
+			want: []FieldChange{
+				{Path: "containers[0].image", From: "web:1.4", To: "web:1.5"},
+				{Path: "containers[1]", From: nil, To: map[string]interface{}{"image": "sidecar:1"}},
+			},
+		},
+		{
+			name: "type change is reported whole",
+			from: map[string]interface{}{"data": "x"},
+			to:   map[string]interface{}{"data": map[string]interface{}{"x": "y"}},
+			want: []FieldChange{{Path: "data", From: "x", To: map[string]interface{}{"x": "y"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fieldChanges("", tt.from, tt.to); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fieldChanges = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
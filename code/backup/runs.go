@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// RunRecord describes one completed backup run. Records are stored outside
+// the clusterbackup/ prefix so git-sync and cleanup never touch them.
+type RunRecord struct {
+	RunID        string    `json:"run_id"`
+	Cluster      string    `json:"cluster"`
+	Label        string    `json:"label,omitempty"`
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+	SnapshotMode bool      `json:"snapshot_mode"`
+	Namespaces   int       `json:"namespaces"`
+	Resources    int       `json:"resources"`
+	// Result is "success", "partial" when namespaces failed or "failure"
+	// when all of them failed. Records written before results were recorded
+	// have none. Failed resource types
+	// of stored namespaces only count in ResourceErrors, the run is still
+	// complete.
+	Result           string `json:"result,omitempty"`
+	FailedNamespaces int    `json:"failed_namespaces,omitempty"`
+	ResourceErrors   int    `json:"resource_errors,omitempty"`
+}
+
+// Results of a recorded run.
+const (
+	runResultSuccess = "success"
+	runResultPartial = "partial"
+	runResultFailure = "failure"
+)
+
+// complete reports whether a run stored every selected namespace.
+func (r RunRecord) complete() bool {
+	return r.Result == "" || r.Result == runResultSuccess
+}
+
+// PinRecord protects a run from retention cleanup until it is unpinned.
+type PinRecord struct {
+	RunID    string    `json:"run_id"`
+	Label    string    `json:"label,omitempty"`
+	PinnedAt time.Time `json:"pinned_at"`
+}
+
+// metaPrefix is where run records, pins and other bookkeeping for this
+// cluster are stored.
+func (cb *ClusterBackup) metaPrefix() string {
+	return fmt.Sprintf("clustermeta/%s/", cb.config.ClusterName)
+}
+
+func (cb *ClusterBackup) putJSON(key string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = cb.minioClient.PutObject(cb.ctx, cb.config.MinIOBucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType:          "application/json",
+		ServerSideEncryption: cb.sse,
+	})
+	return err
+}
+
+func (cb *ClusterBackup) getJSON(key string, value interface{}) error {
+	object, err := cb.minioClient.GetObject(cb.ctx, cb.config.MinIOBucket, key, cb.getObjectOptions())
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(object)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+func (cb *ClusterBackup) writeRunRecord(record RunRecord) error {
+	return cb.putJSON(cb.metaPrefix()+"runs/"+record.RunID+".json", record)
+}
+
+// listRunRecords returns all recorded runs of this cluster, newest first.
+func (cb *ClusterBackup) listRunRecords() ([]RunRecord, error) {
+	var records []RunRecord
+	for object := range cb.minioClient.ListObjects(cb.ctx, cb.config.MinIOBucket, minio.ListObjectsOptions{
+		Prefix: cb.metaPrefix() + "runs/",
+	}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		var record RunRecord
+		if err := cb.getJSON(object.Key, &record); err != nil {
+			cb.logger.Warn("run_record_unreadable", "Skipping unreadable run record", map[string]interface{}{
+				"object_key": object.Key,
+				"error":      err.Error(),
+			})
+			continue
+		}
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].RunID > records[j].RunID
+	})
+	return records, nil
+}
+
+// resolveRun accepts a run ID or a run label and returns the run ID. Labels
+// resolve to the newest run carrying them.
+func (cb *ClusterBackup) resolveRun(ref string) (RunRecord, error) {
+	records, err := cb.listRunRecords()
+	if err != nil {
+		return RunRecord{}, fmt.Errorf("failed to list runs: %v", err)
+	}
+	for _, record := range records {
+		if record.RunID == ref || record.Label == ref {
+			return record, nil
+		}
+	}
+	return RunRecord{}, fmt.Errorf("no run with ID or label %q", ref)
+}
+
+// pinnedRuns returns the pins of this cluster keyed by run ID.
+func (cb *ClusterBackup) pinnedRuns() (map[string]PinRecord, error) {
+	pins := make(map[string]PinRecord)
+	for object := range cb.minioClient.ListObjects(cb.ctx, cb.config.MinIOBucket, minio.ListObjectsOptions{
+		Prefix: cb.metaPrefix() + "pins/",
+	}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		var pin PinRecord
+		if err := cb.getJSON(object.Key, &pin); err != nil {
+			return nil, fmt.Errorf("failed to read pin %s: %v", object.Key, err)
+		}
+		pins[pin.RunID] = pin
+	}
+	return pins, nil
+}
+
+// pinRun protects a run from retention cleanup. Only snapshot runs can be
+// pinned: in the flat layout the next run overwrites the objects of a run,
+// so a pin would protect nothing.
+func (cb *ClusterBackup) pinRun(ref string) (PinRecord, error) {
+	record, err := cb.resolveRun(ref)
+	if err != nil {
+		return PinRecord{}, err
+	}
+	if !record.SnapshotMode {
+		return PinRecord{}, fmt.Errorf("run %s was written in the flat layout and is overwritten by later runs, only snapshot-mode runs can be pinned", record.RunID)
+	}
+	pin := PinRecord{RunID: record.RunID, Label: record.Label, PinnedAt: time.Now().UTC()}
+	if err := cb.putJSON(cb.metaPrefix()+"pins/"+record.RunID+".json", pin); err != nil {
+		return PinRecord{}, fmt.Errorf("failed to pin run %s: %v", record.RunID, err)
+	}
+	// Lifecycle expiration would delete the run whatever the pin says. The
+	// rules are removed right away, the pin command runs in its own process
+	// and the next backup may be far off
+	if err := cb.removeOwnedLifecycleRules(); err != nil {
+		return pin, fmt.Errorf("run %s pinned, but removing lifecycle expiration failed: %v", record.RunID, err)
+	}
+	cb.lifecycleActive = false
+	return pin, nil
+}
+
+func (cb *ClusterBackup) unpinRun(ref string) (string, error) {
+	runID := ref
+	if record, err := cb.resolveRun(ref); err == nil {
+		runID = record.RunID
+	}
+	key := cb.metaPrefix() + "pins/" + runID + ".json"
+	if _, err := cb.minioClient.StatObject(cb.ctx, cb.config.MinIOBucket, key, cb.statObjectOptions()); err != nil {
+		return "", fmt.Errorf("run %s is not pinned", ref)
+	}
+	if err := cb.minioClient.RemoveObject(cb.ctx, cb.config.MinIOBucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return "", fmt.Errorf("failed to unpin run %s: %v", runID, err)
+	}
+	return runID, nil
+}
+
+// objectRunID returns the run that wrote an object: the snapshot prefix in
+// snapshot mode, otherwise the run-id user metadata.
+func (cb *ClusterBackup) objectRunID(object minio.ObjectInfo) string {
+	rest := strings.TrimPrefix(object.Key, cb.clusterPrefix())
+	if strings.HasPrefix(rest, "snapshots/") {
+		if parts := strings.SplitN(rest, "/", 3); len(parts) == 3 {
+			return parts[1]
+		}
+	}
+
+	metadata := object.UserMetadata
+	if len(metadata) == 0 {
+		info, err := cb.minioClient.StatObject(cb.ctx, cb.config.MinIOBucket, object.Key, cb.statObjectOptions())
+		if err != nil {
+			return ""
+		}
+		metadata = info.UserMetadata
+	}
+	return userMetadataValue(metadata, metaRunID)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultStatusConfigMap is the ConfigMap the outcome of each run is
+// published to, next to backup-config.
+const defaultStatusConfigMap = "backup-status"
+
+// maxRecordedWarnings bounds the warnings kept in the status ConfigMap.
+const maxRecordedWarnings = 50
+
+// podNamespace is the namespace of the backup pod, where backup-config is
+// read from and status is written to.
+func podNamespace() string {
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace
+	}
+	return "default"
+}
+
+// podReference returns the pod this process runs in, the object events are
+// reported on. The UID is filled in when the pod can be read.
+func (cb *ClusterBackup) podReference() *corev1.ObjectReference {
+	if cb.podRef != nil {
+		return cb.podRef
+	}
+	name := os.Getenv("POD_NAME")
+	if name == "" {
+		// Pods use their name as hostname
+		name, _ = os.Hostname()
+	}
+	ref := &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  podNamespace(),
+		Name:       name,
+	}
+	if pod, err := cb.kubeClient.CoreV1().Pods(ref.Namespace).Get(cb.ctx, name, metav1.GetOptions{}); err == nil {
+		ref.UID = pod.UID
+		ref.ResourceVersion = pod.ResourceVersion
+	}
+	cb.podRef = ref
+	return ref
+}
+
+// recordWarning keeps a warning for the status ConfigMap and reports it as a
+// Warning event on the backup pod. Repeated warnings are reported once.
+func (cb *ClusterBackup) recordWarning(reason, message string) {
+	key := reason + "/" + message
+	if cb.warningsSeen == nil {
+		cb.warningsSeen = make(map[string]bool)
+	}
+	if cb.warningsSeen[key] {
+		return
+	}
+	cb.warningsSeen[key] = true
+
+	if len(cb.runStats.Warnings) < maxRecordedWarnings {
+		cb.runStats.Warnings = append(cb.runStats.Warnings, reason+": "+message)
+	}
+	cb.emitEvent(corev1.EventTypeWarning, reason, message)
+}
+
+// emitEvent creates an event on the backup pod. Failures are logged and
+// otherwise ignored, events are best effort.
+func (cb *ClusterBackup) emitEvent(eventType, reason, message string) {
+	if !cb.backupConfig.PublishEvents {
+		return
+	}
+	ref := cb.podReference()
+	if ref.Name == "" {
+		return
+	}
+
+	now := metav1.Now()
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: ref.Name + ".",
+			Namespace:    ref.Namespace,
+		},
+		InvolvedObject:      *ref,
+		Reason:              reason,
+		Message:             message,
+		Type:                eventType,
+		Source:              corev1.EventSource{Component: "cluster-backup"},
+		FirstTimestamp:      now,
+		LastTimestamp:       now,
+		Count:               1,
+		ReportingController: "cluster-backup",
+		ReportingInstance:   ref.Name,
+	}
+	if _, err := cb.kubeClient.CoreV1().Events(ref.Namespace).Create(cb.ctx, event, metav1.CreateOptions{}); err != nil {
+		cb.logger.Debug("event_failed", "Failed to create event", map[string]interface{}{
+			"reason": reason,
+			"error":  err.Error(),
+		})
+	}
+}
+
+// updateStatusConfigMap merges data into the status ConfigMap, creating it
+// on first use. Keys not in data are kept, so backup and cleanup results can
+// be written independently.
+func (cb *ClusterBackup) updateStatusConfigMap(data map[string]string) error {
+	name := cb.backupConfig.StatusConfigMap
+	if name == "" {
+		return nil
+	}
+	configMaps := cb.kubeClient.CoreV1().ConfigMaps(podNamespace())
+
+	configMap, err := configMaps.Get(cb.ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: podNamespace(),
+				Labels: map[string]string{
+					"app.kubernetes.io/name":       "cluster-backup",
+					"app.kubernetes.io/managed-by": "cluster-backup",
+				},
+			},
+			Data: data,
+		}
+		_, err = configMaps.Create(cb.ctx, configMap, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	for key, value := range data {
+		configMap.Data[key] = value
+	}
+	_, err = configMaps.Update(cb.ctx, configMap, metav1.UpdateOptions{})
+	return err
+}
+
+// publishRunStatus writes the outcome of Run to the status ConfigMap and
+// reports it as an event on the backup pod.
+func (cb *ClusterBackup) publishRunStatus(runErr error) {
+	result := "success"
+	var errors []string
+	if runErr != nil {
+		result = "failure"
+		errors = append(errors, runErr.Error())
+	}
+	namespaces := make([]string, 0, len(cb.runStats.Failures))
+	for namespace := range cb.runStats.Failures {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		errors = append(errors, namespace+": "+cb.runStats.Failures[namespace])
+	}
+	// A run that stored nothing because every namespace failed is a failure,
+	// one where some namespaces failed or warnings were raised is partial
+	allFailed := cb.runStats.Namespaces > 0 && len(cb.runStats.Failures) == cb.runStats.Namespaces
+	switch {
+	case runErr != nil:
+	case allFailed:
+		result = "failure"
+	case len(errors) > 0 || len(cb.runStats.Warnings) > 0:
+		result = "partial"
+	}
+
+	snapshotID := ""
+	if cb.backupConfig.SnapshotMode && result != "failure" {
+		snapshotID = cb.runID
+	}
+	errorList, _ := json.Marshal(nonNil(errors))
+	warningList, _ := json.Marshal(nonNil(cb.runStats.Warnings))
+
+	err := cb.updateStatusConfigMap(map[string]string{
+		"cluster":           cb.config.ClusterName,
+		"run-id":            cb.runID,
+		"snapshot-id":       snapshotID,
+		"last-start":        cb.runStats.StartedAt.UTC().Format(time.RFC3339),
+		"last-end":          time.Now().UTC().Format(time.RFC3339),
+		"result":            result,
+		"namespaces":        strconv.Itoa(cb.runStats.Namespaces),
+		"resources":         strconv.Itoa(cb.runStats.Resources),
+		"failed-namespaces": strconv.Itoa(len(cb.runStats.Failures)),
+		"errors":            string(errorList),
+		"warnings":          string(warningList),
+	})
+	if err != nil {
+		cb.logger.Warn("status_publish_failed", "Failed to write status ConfigMap", map[string]interface{}{
+			"configmap": cb.backupConfig.StatusConfigMap,
+			"namespace": podNamespace(),
+			"error":     err.Error(),
+		})
+	}
+
+	switch result {
+	case "success":
+		cb.emitEvent(corev1.EventTypeNormal, "BackupSucceeded", fmt.Sprintf("Backup %s stored %d resources from %d namespaces",
+			cb.runID, cb.runStats.Resources, cb.runStats.Namespaces))
+	case "partial":
+		cb.emitEvent(corev1.EventTypeWarning, "BackupCompletedWithErrors", fmt.Sprintf("Backup %s stored %d resources from %d namespaces with %d errors and %d warnings",
+			cb.runID, cb.runStats.Resources, cb.runStats.Namespaces, len(errors), len(cb.runStats.Warnings)))
+	default:
+		reason := fmt.Sprintf("all %d namespaces failed, first: %s", cb.runStats.Namespaces, errors[0])
+		if runErr != nil {
+			reason = runErr.Error()
+		}
+		cb.emitEvent(corev1.EventTypeWarning, "BackupFailed", fmt.Sprintf("Backup %s failed: %s", cb.runID, reason))
+	}
+}
+
+// publishCleanupStatus writes the outcome of a cleanup to the status
+// ConfigMap and reports failures as events.
+func (cb *ClusterBackup) publishCleanupStatus(startedAt time.Time, cleanupErr error) {
+	result, message := "success", ""
+	if cleanupErr != nil {
+		result, message = "failure", cleanupErr.Error()
+		cb.recordWarning("CleanupFailed", message)
+	}
+	err := cb.updateStatusConfigMap(map[string]string{
+		"last-cleanup-start":         startedAt.UTC().Format(time.RFC3339),
+		"last-cleanup-end":           time.Now().UTC().Format(time.RFC3339),
+		"last-cleanup-result":        result,
+		"last-cleanup-cleaned-files": strconv.Itoa(cb.cleanupStats.CleanedFiles),
+		"last-cleanup-error":         message,
+	})
+	if err != nil {
+		cb.logger.Warn("status_publish_failed", "Failed to write status ConfigMap", map[string]interface{}{
+			"configmap": cb.backupConfig.StatusConfigMap,
+			"namespace": podNamespace(),
+			"error":     err.Error(),
+		})
+	}
+}
+
+// nonNil makes empty lists serialize as [] rather than null.
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
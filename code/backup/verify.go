@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ManifestEntry records one object written by a run.
+type ManifestEntry struct {
+	Key       string `json:"key"`
+	Namespace string `json:"namespace"`
+	Group     string `json:"group"`
+	Version   string `json:"version"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	SHA256    string `json:"sha256"`
+	Size      int64  `json:"size"`
+	// HelmRelease is the release the object belongs to, if any
+	HelmRelease string `json:"helm_release,omitempty"`
+	// UID and Owners record the owner graph of the run
+	UID    string      `json:"uid,omitempty"`
+	Owners []OwnerLink `json:"owners,omitempty"`
+	// Overrides lists the backup.cluster/ annotations in effect and whether
+	// they were set on the object or its namespace
+	Overrides []string `json:"overrides,omitempty"`
+	// IncludedBy is set on objects that were not selected themselves but
+	// referenced by a selected object
+	IncludedBy string `json:"included_by,omitempty"`
+}
+
+// RunManifest lists every object a run wrote, so a later verification can
+// tell missing and unexpected objects apart from corrupt ones.
+type RunManifest struct {
+	RunID     string          `json:"run_id"`
+	Cluster   string          `json:"cluster"`
+	ClusterID string          `json:"cluster_id,omitempty"`
+	Objects   []ManifestEntry `json:"objects"`
+	// CRDs lists the CRDs stored with their custom resources; restore
+	// applies them first
+	CRDs []CRDRecord `json:"crds,omitempty"`
+}
+
+func (cb *ClusterBackup) manifestKey(runID string) string {
+	return cb.metaPrefix() + "manifests/" + runID + ".json"
+}
+
+// manifestEntry describes an uploaded object. It reads the live object, not
+// its cleaned form, which no longer has a UID.
+func (cb *ClusterBackup) manifestEntry(key, namespace string, gvr schema.GroupVersionResource, item *unstructured.Unstructured, userMetadata map[string]string, size int64) ManifestEntry {
+	_, helmRelease := helmReleaseOf(item)
+	return ManifestEntry{
+		Key:         key,
+		Namespace:   namespace,
+		Group:       userMetadata[metaGroup],
+		Version:     gvr.Version,
+		Kind:        item.GetKind(),
+		Name:        item.GetName(),
+		SHA256:      userMetadata[metaContentSHA256],
+		Size:        size,
+		HelmRelease: helmRelease,
+		UID:         string(item.GetUID()),
+		Owners:      cb.ownerLinks(item),
+		Overrides:   cb.appliedOverrides(item),
+	}
+}
+
+// recordManifestEntry adds an uploaded object to the manifest of this run.
+// Uploading the same key again replaces its entry.
+func (cb *ClusterBackup) recordManifestEntry(entry ManifestEntry) {
+	cb.manifestMu.Lock()
+	defer cb.manifestMu.Unlock()
+	if cb.manifestIndex == nil {
+		cb.manifestIndex = make(map[string]int)
+	}
+	if i, ok := cb.manifestIndex[entry.Key]; ok {
+		cb.manifest[i] = entry
+		return
+	}
+	cb.manifestIndex[entry.Key] = len(cb.manifest)
+	cb.manifest = append(cb.manifest, entry)
+}
+
+// manifestHas reports whether this run already uploaded key.
+func (cb *ClusterBackup) manifestHas(key string) bool {
+	cb.manifestMu.Lock()
+	defer cb.manifestMu.Unlock()
+	_, ok := cb.manifestIndex[key]
+	return ok
+}
+
+// markIncludedBy records why an object outside the selection was uploaded.
+func (cb *ClusterBackup) markIncludedBy(key, reason string) {
+	cb.manifestMu.Lock()
+	defer cb.manifestMu.Unlock()
+	if i, ok := cb.manifestIndex[key]; ok {
+		cb.manifest[i].IncludedBy = reason
+	}
+}
+
+func (cb *ClusterBackup) writeManifest() error {
+	cb.manifestMu.Lock()
+	objects := append([]ManifestEntry(nil), cb.manifest...)
+	crds := append([]CRDRecord(nil), cb.crdRecords...)
+	cb.manifestMu.Unlock()
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	sort.Slice(crds, func(i, j int) bool { return crds[i].Name < crds[j].Name })
+	return cb.putJSON(cb.manifestKey(cb.runID), RunManifest{
+		RunID:     cb.runID,
+		Cluster:   cb.config.ClusterName,
+		ClusterID: cb.clusterID,
+		Objects:   objects,
+		CRDs:      crds,
+	})
+}
+
+// readManifest returns the manifest of a run, nil for runs recorded before
+// manifests were written.
+func (cb *ClusterBackup) readManifest(runID string) (*RunManifest, error) {
+	var manifest RunManifest
+	if err := cb.getJSON(cb.manifestKey(runID), &manifest); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// etagIsMD5 reports whether S3 returns the MD5 of the content as ETag for
+// single-part uploads, which is not the case with SSE-KMS and SSE-C.
+func (cb *ClusterBackup) etagIsMD5() bool {
+	return cb.sse == nil || cb.sse.Type() == encrypt.S3
+}
+
+// etagIsContentMD5 reports whether the ETag of a stored object is the MD5 of
+// its content. That depends on how the object is encrypted at rest, which
+// may be the bucket's default encryption rather than what this client
+// requested, so it is read from the response headers: SSE-KMS and SSE-C
+// objects have other ETags.
+func etagIsContentMD5(info minio.ObjectInfo) bool {
+	if strings.HasPrefix(info.Metadata.Get("X-Amz-Server-Side-Encryption"), "aws:kms") {
+		return false
+	}
+	return info.Metadata.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") == ""
+}
+
+// verifyUpload checks that an uploaded object matches what was serialized.
+// The ETag is compared against the content MD5 where S3 guarantees that
+// relationship; otherwise the object is read back and hashed.
+func (cb *ClusterBackup) verifyUpload(key string, data []byte, info minio.UploadInfo) error {
+	stat, err := cb.minioClient.StatObject(cb.ctx, cb.config.MinIOBucket, key, cb.statObjectOptions())
+	if err != nil {
+		return fmt.Errorf("failed to stat uploaded object: %v", err)
+	}
+	if stat.Size != int64(len(data)) {
+		return fmt.Errorf("size mismatch: wrote %d bytes, stored %d", len(data), stat.Size)
+	}
+	if info.ETag != "" && stat.ETag != info.ETag {
+		return fmt.Errorf("etag mismatch: upload returned %s, stored %s", info.ETag, stat.ETag)
+	}
+
+	if etagIsContentMD5(stat) && !strings.Contains(stat.ETag, "-") {
+		sum := md5.Sum(data)
+		if expected := hex.EncodeToString(sum[:]); !strings.EqualFold(stat.ETag, expected) {
+			return fmt.Errorf("checksum mismatch: expected md5 %s, stored etag %s", expected, stat.ETag)
+		}
+		return nil
+	}
+
+	stored, err := cb.readObjectVersion(key, "")
+	if err != nil {
+		return fmt.Errorf("failed to read back uploaded object: %v", err)
+	}
+	if !bytes.Equal(stored, data) {
+		return fmt.Errorf("checksum mismatch: expected sha256 %s, stored %s", contentHash(data), contentHash(stored))
+	}
+	return nil
+}
+
+// VerifyProblem describes one object that failed verification.
+type VerifyProblem struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+// VerifyReport is the result of verifying a stored run.
+type VerifyReport struct {
+	RunID      string          `json:"run_id"`
+	Prefix     string          `json:"prefix"`
+	Manifest   bool            `json:"manifest"`
+	Checked    int             `json:"checked"`
+	Valid      int             `json:"valid"`
+	Missing    []string        `json:"missing"`
+	Corrupt    []VerifyProblem `json:"corrupt"`
+	Unexpected []string        `json:"unexpected"`
+	Unverified []string        `json:"unverified"`
+	// Stale lists objects of the flat layout that the run did not write,
+	// left by earlier runs until cleanup removes them. They are not checked.
+	Stale []string `json:"stale,omitempty"`
+}
+
+func (r *VerifyReport) failed() bool {
+	return len(r.Missing) > 0 || len(r.Corrupt) > 0 || len(r.Unexpected) > 0
+}
+
+// verifyRun re-downloads every object of a run, checks it parses as a
+// Kubernetes object and matches the hash recorded at upload time, and
+// compares the stored objects with the run manifest. An empty ref verifies
+// the newest run.
+func (cb *ClusterBackup) verifyRun(ref string) (*VerifyReport, error) {
+	records, err := cb.listRunRecords()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list runs: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no runs recorded for cluster %s", cb.config.ClusterName)
+	}
+	record := records[0]
+	if ref != "" {
+		if record, err = cb.resolveRun(ref); err != nil {
+			return nil, err
+		}
+	}
+
+	// The flat layout is overwritten by every run, so only the newest run
+	// can be compared against it
+	prefix := cb.clusterPrefix()
+	if record.SnapshotMode {
+		prefix = cb.snapshotsPrefix() + record.RunID + "/"
+	} else if record.RunID != records[0].RunID {
+		return nil, fmt.Errorf("run %s used the flat layout and was overwritten by run %s", record.RunID, records[0].RunID)
+	}
+
+	manifest, err := cb.readManifest(record.RunID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest of run %s: %v", record.RunID, err)
+	}
+
+	report := &VerifyReport{
+		RunID:      record.RunID,
+		Prefix:     prefix,
+		Manifest:   manifest != nil,
+		Missing:    []string{},
+		Corrupt:    []VerifyProblem{},
+		Unexpected: []string{},
+		Unverified: []string{},
+	}
+
+	expected := make(map[string]ManifestEntry)
+	if manifest != nil {
+		for _, entry := range manifest.Objects {
+			expected[entry.Key] = entry
+		}
+	} else {
+		cb.logger.Warn("verify_no_manifest", "Run has no manifest, missing objects cannot be detected", map[string]interface{}{
+			"run_id": record.RunID,
+		})
+	}
+
+	seen := make(map[string]bool)
+	for object := range cb.minioClient.ListObjects(cb.ctx, cb.config.MinIOBucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed to list %s: %v", prefix, object.Err)
+		}
+		if !record.SnapshotMode && strings.HasPrefix(object.Key, cb.snapshotsPrefix()) {
+			continue
+		}
+		seen[object.Key] = true
+
+		// In the flat layout objects of earlier runs are expected, only a
+		// snapshot prefix has to match its manifest exactly
+		entry, inManifest := expected[object.Key]
+		if manifest != nil && !inManifest {
+			if record.SnapshotMode {
+				report.Unexpected = append(report.Unexpected, object.Key)
+			} else {
+				report.Stale = append(report.Stale, object.Key)
+			}
+			continue
+		}
+
+		report.Checked++
+		recordedHash := entry.SHA256
+		reason, hash := cb.verifyStoredObject(object.Key, &recordedHash)
+		switch {
+		case reason != "":
+			report.Corrupt = append(report.Corrupt, VerifyProblem{Key: object.Key, Reason: reason})
+		case recordedHash == "":
+			report.Unverified = append(report.Unverified, object.Key)
+		case hash != recordedHash:
+			report.Corrupt = append(report.Corrupt, VerifyProblem{
+				Key:    object.Key,
+				Reason: fmt.Sprintf("sha256 mismatch: recorded %s, stored %s", recordedHash, hash),
+			})
+		default:
+			report.Valid++
+		}
+	}
+
+	for key := range expected {
+		if !seen[key] {
+			report.Missing = append(report.Missing, key)
+		}
+	}
+	sort.Strings(report.Missing)
+	return report, nil
+}
+
+// verifyStoredObject downloads an object and checks that it is a Kubernetes
+// object. It returns a failure reason, or the content hash. When
+// recordedHash is empty it is filled from the object's user metadata.
+func (cb *ClusterBackup) verifyStoredObject(key string, recordedHash *string) (string, string) {
+	object, err := cb.minioClient.GetObject(cb.ctx, cb.config.MinIOBucket, key, cb.getObjectOptions())
+	if err != nil {
+		return fmt.Sprintf("read failed: %v", err), ""
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(object)
+	if err != nil {
+		return fmt.Sprintf("read failed: %v", err), ""
+	}
+	if *recordedHash == "" {
+		if info, err := object.Stat(); err == nil {
+			*recordedHash = userMetadataValue(info.UserMetadata, metaContentSHA256)
+		}
+	}
+
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal(data, &parsed); err != nil {
+		return fmt.Sprintf("invalid YAML: %v", err), ""
+	}
+	apiVersion, _ := parsed["apiVersion"].(string)
+	kind, _ := parsed["kind"].(string)
+	metadata, _ := parsed["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	if apiVersion == "" || kind == "" || name == "" {
+		return "not a Kubernetes object: apiVersion, kind or metadata.name missing", ""
+	}
+
+	return "", contentHash(data)
+}
+
+// runVerify verifies a run, prints the report to stdout and returns an
+// error when any object is missing, corrupt or unexpected.
+func (cb *ClusterBackup) runVerify(ref string) error {
+	startTime := time.Now()
+	report, err := cb.verifyRun(ref)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+
+	summary := map[string]interface{}{
+		"run_id":      report.RunID,
+		"checked":     report.Checked,
+		"valid":       report.Valid,
+		"missing":     len(report.Missing),
+		"corrupt":     len(report.Corrupt),
+		"unexpected":  len(report.Unexpected),
+		"unverified":  len(report.Unverified),
+		"duration_ms": time.Since(startTime).Milliseconds(),
+	}
+	if report.failed() {
+		cb.logger.Error("verify_failed", "Backup verification found problems", summary)
+		return fmt.Errorf("verification of run %s failed: %d missing, %d corrupt, %d unexpected",
+			report.RunID, len(report.Missing), len(report.Corrupt), len(report.Unexpected))
+	}
+	cb.logger.Info("verify_complete", "Backup verification passed", summary)
+	return nil
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"gopkg.in/yaml.v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ObjectVersion is one entry in the version history of a stored object.
+type ObjectVersion struct {
+	VersionID    string    `json:"version_id"`
+	LastModified time.Time `json:"last_modified"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag,omitempty"`
+	IsLatest     bool      `json:"is_latest"`
+	DeleteMarker bool      `json:"delete_marker,omitempty"`
+}
+
+// requireVersioning fails when the bucket keeps no object versions.
+func (cb *ClusterBackup) requireVersioning() error {
+	versioned, err := cb.bucketVersioned()
+	if err != nil {
+		return fmt.Errorf("failed to read bucket versioning: %v", err)
+	}
+	if !versioned {
+		return fmt.Errorf("bucket %s does not have versioning enabled", cb.config.MinIOBucket)
+	}
+	return nil
+}
+
+// resolveObjectKey accepts a full object key or one relative to this
+// cluster's prefix, e.g. "default/deployments/web.yaml".
+func (cb *ClusterBackup) resolveObjectKey(key string) string {
+	if strings.HasPrefix(key, "clusterbackup/") {
+		return key
+	}
+	key = strings.TrimPrefix(key, "/")
+	if !strings.HasSuffix(key, ".yaml") {
+		key += ".yaml"
+	}
+	return cb.clusterPrefix() + key
+}
+
+// objectVersions returns the version history of a key, newest first.
+func (cb *ClusterBackup) objectVersions(key string) ([]ObjectVersion, error) {
+	var versions []ObjectVersion
+	for object := range cb.minioClient.ListObjects(cb.ctx, cb.config.MinIOBucket, minio.ListObjectsOptions{
+		Prefix:       key,
+		WithVersions: true,
+	}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		if object.Key != key {
+			continue
+		}
+		versions = append(versions, ObjectVersion{
+			VersionID:    object.VersionID,
+			LastModified: object.LastModified,
+			Size:         object.Size,
+			ETag:         object.ETag,
+			IsLatest:     object.IsLatest,
+			DeleteMarker: object.IsDeleteMarker,
+		})
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+	return versions, nil
+}
+
+// printObjectVersions writes the version history of a key to stdout.
+func (cb *ClusterBackup) printObjectVersions(key string) error {
+	if err := cb.requireVersioning(); err != nil {
+		return err
+	}
+	key = cb.resolveObjectKey(key)
+	versions, err := cb.objectVersions(key)
+	if err != nil {
+		return fmt.Errorf("failed to list versions of %s: %v", key, err)
+	}
+	if len(versions) == 0 {
+		return fmt.Errorf("no versions of %s found", key)
+	}
+
+	report := struct {
+		Key      string          `json:"key"`
+		Versions []ObjectVersion `json:"versions"`
+	}{Key: key, Versions: versions}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// readObjectVersion returns the content of one version of a key; an empty
+// version ID reads the current version.
+func (cb *ClusterBackup) readObjectVersion(key, versionID string) ([]byte, error) {
+	options := cb.getObjectOptions()
+	options.VersionID = versionID
+	object, err := cb.minioClient.GetObject(cb.ctx, cb.config.MinIOBucket, key, options)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	return io.ReadAll(object)
+}
+
+// printObjectVersion writes one version of a key to stdout.
+func (cb *ClusterBackup) printObjectVersion(key, versionID string) error {
+	key = cb.resolveObjectKey(key)
+	data, err := cb.readObjectVersion(key, versionID)
+	if err != nil {
+		return fmt.Errorf("failed to read %s version %s: %v", key, versionID, err)
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// parseTimestamp accepts RFC 3339 timestamps, run IDs and plain dates.
+func parseTimestamp(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "20060102T150405Z", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q (expected RFC 3339, a run ID or YYYY-MM-DD)", value)
+}
+
+// versionsAsOf picks, for every key below prefix, the newest version written
+// at or before asOf. Keys whose newest such version is a delete marker did
+// not exist at that time and are left out. Snapshot prefixes are skipped:
+// snapshots are never overwritten, so their history is the snapshot list,
+// see restoreSource.
+func (cb *ClusterBackup) versionsAsOf(prefix string, asOf time.Time) (map[string]minio.ObjectInfo, error) {
+	var versions []minio.ObjectInfo
+	for object := range cb.minioClient.ListObjects(cb.ctx, cb.config.MinIOBucket, minio.ListObjectsOptions{
+		Prefix:       prefix,
+		Recursive:    true,
+		WithVersions: true,
+	}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		versions = append(versions, object)
+	}
+	return selectVersionsAsOf(versions, cb.snapshotsPrefix(), asOf), nil
+}
+
+// selectVersionsAsOf implements versionsAsOf on a version listing.
+func selectVersionsAsOf(versions []minio.ObjectInfo, snapshotsPrefix string, asOf time.Time) map[string]minio.ObjectInfo {
+	selected := make(map[string]minio.ObjectInfo)
+	for _, object := range versions {
+		if strings.HasPrefix(object.Key, snapshotsPrefix) || object.LastModified.After(asOf) {
+			continue
+		}
+		if current, ok := selected[object.Key]; ok && !object.LastModified.After(current.LastModified) {
+			continue
+		}
+		selected[object.Key] = object
+	}
+
+	for key, object := range selected {
+		if object.IsDeleteMarker {
+			delete(selected, key)
+		}
+	}
+	return selected
+}
+
+// restoreAsOf restores the cluster, or one namespace of it, as it was stored
+// at asOf. Objects are written below outputDir and, with apply, server-side
+// applied to the live cluster. Unless includeOwned is set, objects whose
+// controller owner is restored too are not applied.
+func (cb *ClusterBackup) restoreAsOf(asOf time.Time, namespace, outputDir string, apply, includeOwned bool) error {
+	base, source, selected, err := cb.restoreSource(asOf, namespace)
+	if err != nil {
+		return err
+	}
+	if len(selected) == 0 {
+		return fmt.Errorf("no objects of %s stored at or before %s", source, asOf.UTC().Format(time.RFC3339))
+	}
+
+	keys := make([]string, 0, len(selected))
+	for key := range selected {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var restored, applied, skippedOwned int
+	var errors []string
+	contents := make(map[string][]byte, len(keys))
+	owners := make(ownerIndex)
+	for _, key := range keys {
+		data, err := cb.readObjectVersion(key, selected[key])
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("read %s: %v", key, err))
+			continue
+		}
+		contents[key] = data
+		var object map[string]interface{}
+		if yaml.Unmarshal(data, &object) == nil {
+			owners.add(object)
+		}
+	}
+
+	var appliedCRDs []string
+	crdsWaited := false
+	for _, key := range restoreOrder(keys, base) {
+		data, ok := contents[key]
+		if !ok {
+			continue
+		}
+		rel := strings.TrimPrefix(key, base)
+
+		if outputDir != "" {
+			target := filepath.Join(outputDir, filepath.FromSlash(rel))
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				errors = append(errors, fmt.Sprintf("write %s: %v", target, err))
+				continue
+			}
+			if err := os.WriteFile(target, data, 0o600); err != nil {
+				errors = append(errors, fmt.Sprintf("write %s: %v", target, err))
+				continue
+			}
+		}
+		restored++
+
+		// Release summaries are for reinstalling with helm, not objects, and
+		// projects are recreated with their namespace
+		if !apply || isHelmReleaseSummaryKey(rel) || isProjectKey(rel) {
+			continue
+		}
+		// Custom resources can only be applied once their CRDs are served
+		if !isCRDKey(rel) && len(appliedCRDs) > 0 && !crdsWaited {
+			crdsWaited = true
+			if err := cb.waitForCRDs(appliedCRDs); err != nil {
+				errors = append(errors, err.Error())
+			}
+		}
+		// Children whose controller owner is applied as well are recreated by
+		// it; applying them too would race the controller
+		if !includeOwned {
+			var object map[string]interface{}
+			if yaml.Unmarshal(data, &object) == nil && owners.ownerRestored(object) {
+				skippedOwned++
+				cb.logger.Debug("restore_skip_owned", "Skipping object recreated by its restored owner", map[string]interface{}{
+					"object_key": key,
+				})
+				continue
+			}
+		}
+		if err := cb.applyStoredObject(rel, data); err != nil {
+			errors = append(errors, fmt.Sprintf("apply %s: %v", key, err))
+			continue
+		}
+		if isCRDKey(rel) {
+			_, _, name, _ := splitRelativeKey(rel)
+			appliedCRDs = append(appliedCRDs, name)
+		}
+		applied++
+	}
+
+	summary := map[string]interface{}{
+		"as_of":      asOf.UTC().Format(time.RFC3339),
+		"source":     source,
+		"namespace":  namespace,
+		"output_dir": outputDir,
+		"objects":    len(keys),
+		"restored":   restored,
+		"errors":     len(errors),
+	}
+	if apply {
+		summary["applied"] = applied
+		summary["skipped_owned"] = skippedOwned
+	}
+
+	if len(errors) > 0 {
+		cb.logger.Error("restore_complete_with_errors", "Restore completed with errors", summary)
+		return fmt.Errorf("restore failed with %d errors, first: %s", len(errors), errors[0])
+	}
+	cb.logger.Info("restore_complete", "Restore completed", summary)
+	return nil
+}
+
+// restoreSource selects what restoreAsOf restores: the keys with the version
+// to read, below base. Clusters with snapshots restore the newest complete
+// snapshot taken at or before asOf; the flat layout restores the object
+// versions current at asOf, which needs a versioned bucket.
+func (cb *ClusterBackup) restoreSource(asOf time.Time, namespace string) (string, string, map[string]string, error) {
+	snapshots, err := cb.listSnapshots()
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to list snapshots: %v", err)
+	}
+	if len(snapshots) > 0 || cb.backupConfig.SnapshotMode {
+		snapshot, ok := newestCompleteSnapshot(snapshots, asOf)
+		if !ok {
+			return "", "", nil, fmt.Errorf("no complete snapshot of %s at or before %s", cb.config.ClusterName, asOf.UTC().Format(time.RFC3339))
+		}
+		prefix := snapshot.Prefix
+		if namespace != "" {
+			prefix += namespace + "/"
+		}
+		objects, err := cb.listStoredObjects(prefix)
+		if err != nil {
+			return "", "", nil, fmt.Errorf("failed to list %s: %v", prefix, err)
+		}
+		selected := make(map[string]string, len(objects))
+		for _, object := range objects {
+			selected[object.Key] = ""
+		}
+		return snapshot.Prefix, "snapshot " + snapshot.RunID, selected, nil
+	}
+
+	if err := cb.requireVersioning(); err != nil {
+		return "", "", nil, err
+	}
+	prefix := cb.clusterPrefix()
+	if namespace != "" {
+		prefix += namespace + "/"
+	}
+	versions, err := cb.versionsAsOf(prefix, asOf)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to list versions below %s: %v", prefix, err)
+	}
+	selected := make(map[string]string, len(versions))
+	for key, object := range versions {
+		selected[key] = object.VersionID
+	}
+	return cb.clusterPrefix(), "versions of " + cb.config.ClusterName, selected, nil
+}
+
+// applyStoredObject server-side applies a stored object. The resource name
+// is taken from its key relative to the backup prefix, the group and version
+// from the object itself.
+func (cb *ClusterBackup) applyStoredObject(rel string, data []byte) error {
+	var object map[string]interface{}
+	if err := yaml.Unmarshal(data, &object); err != nil {
+		return fmt.Errorf("failed to parse object: %v", err)
+	}
+
+	parts := strings.Split(rel, "/")
+	if len(parts) != 3 {
+		return fmt.Errorf("unexpected key layout")
+	}
+	apiVersion, _ := object["apiVersion"].(string)
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return fmt.Errorf("invalid apiVersion %q: %v", apiVersion, err)
+	}
+	gvr := gv.WithResource(parts[1])
+
+	metadata, _ := object["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	namespace, _ := metadata["namespace"].(string)
+
+	body, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+
+	force := true
+	patchOptions := metav1.PatchOptions{FieldManager: "cluster-backup-restore", Force: &force}
+	if namespace != "" {
+		_, err = cb.dynamicClient.Resource(gvr).Namespace(namespace).Patch(cb.ctx, name, types.ApplyPatchType, body, patchOptions)
+	} else {
+		_, err = cb.dynamicClient.Resource(gvr).Patch(cb.ctx, name, types.ApplyPatchType, body, patchOptions)
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func TestSelectVersionsAsOf(t *testing.T) {
+	at := func(day int) time.Time { return time.Date(2024, 3, day, 12, 0, 0, 0, time.UTC) }
+	const prefix = "clusterbackup/c1/"
+	versions := []minio.ObjectInfo{
+		{Key: prefix + "app/configmaps/a.yaml", VersionID: "a1", LastModified: at(1)},
+		{Key: prefix + "app/configmaps/a.yaml", VersionID: "a3", LastModified: at(3)},
+		{Key: prefix + "app/configmaps/a.yaml", VersionID: "a2", LastModified: at(2)},
+		{Key: prefix + "app/configmaps/b.yaml", VersionID: "b1", LastModified: at(1)},
+		{Key: prefix + "app/configmaps/b.yaml", VersionID: "b-deleted", LastModified: at(2), IsDeleteMarker: true},
+		{Key: prefix + "app/configmaps/c.yaml", VersionID: "c4", LastModified: at(4)},
+		{Key: prefix + "snapshots/20240301T120000Z/app/configmaps/a.yaml", VersionID: "s1", LastModified: at(1)},
+	}
+
+	tests := []struct {
+		name string
+		asOf time.Time
+		want map[string]string
+	}{
+		{
+			name: "before any version",
+			asOf: at(1).Add(-time.Hour),
+			want: map[string]string{},
+		},
+		{
+			name: "first day",
+			asOf: at(1),
+			want: map[string]string{"app/configmaps/a.yaml": "a1", "app/configmaps/b.yaml": "b1"},
+		},
+		{
+			name: "deleted object is left out",
+			asOf: at(2),
+			want: map[string]string{"app/configmaps/a.yaml": "a2"},
+		},
+		{
+			name: "newest version wins regardless of listing order",
+			asOf: at(5),
+			want: map[string]string{"app/configmaps/a.yaml": "a3", "app/configmaps/c.yaml": "c4"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected := selectVersionsAsOf(versions, prefix+"snapshots/", tt.asOf)
+			got := make(map[string]string, len(selected))
+			for key, object := range selected {
+				got[key[len(prefix):]] = object.VersionID
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("selected %v, want %v", sortedKeys(got), sortedKeys(tt.want))
+			}
+			for key, version := range tt.want {
+				if got[key] != version {
+					t.Errorf("%s: version %q, want %q", key, got[key], version)
+				}
+			}
+		})
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -5,6 +5,7 @@ go 1.24.5
 require (
 	github.com/minio/minio-go/v7 v7.0.94
 	github.com/prometheus/client_golang v1.22.0
+	shared v0.0.0
 )
 
 require (
@@ -31,3 +32,5 @@ require (
 	golang.org/x/text v0.23.0 // indirect
 	google.golang.org/protobuf v1.36.5 // indirect
 )
+
+replace shared => ../shared
@@ -10,22 +10,23 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"shared"
 )
 
 type GitSyncConfig struct {
-	MinIOEndpoint   string
-	MinIOAccessKey  string
-	MinIOSecretKey  string
+	// Object storage connection, credentials and encryption
+	shared.StorageConfig
 	MinIOBucket     string
-	MinIOUseSSL     bool
 	GitRepository   string
 	GitBranch       string
 	GitUsername     string
@@ -35,6 +36,12 @@ type GitSyncConfig struct {
 	WorkDir         string
 	RetryAttempts   int
 	RetryDelay      time.Duration
+	// Notification webhooks
+	NotifyWebhookURL      string
+	NotifySlackWebhookURL string
+	NotifyTeamsWebhookURL string
+	NotifyMode            string
+	NotifyTemplate        string
 }
 
 type GitSyncMetrics struct {
@@ -48,9 +55,21 @@ type GitSyncMetrics struct {
 type GitSync struct {
 	config      *GitSyncConfig
 	minioClient *minio.Client
+	sse         encrypt.ServerSide
 	metrics     *GitSyncMetrics
 	ctx         context.Context
 	logger      *GitSyncLogger
+	notifier    *shared.Notifier
+	stats       syncStats
+}
+
+// syncStats collects the outcome of a sync run for notifications.
+type syncStats struct {
+	StartedAt       time.Time
+	Clusters        int
+	FilesDownloaded int
+	Failures        map[string]string // cluster -> download errors
+	Pushed          bool
 }
 
 type GitSyncLogger struct {
@@ -98,7 +117,9 @@ func main() {
 	// Start metrics server in a goroutine
 	go startGitSyncMetricsServer()
 
-	if err := gitSync.Run(); err != nil {
+	err = gitSync.Run()
+	gitSync.notifySync(err)
+	if err != nil {
 		logger.Fatal("git_sync_run", "Git sync failed", map[string]interface{}{"error": err.Error()})
 	}
 
@@ -118,11 +139,29 @@ func loadGitSyncConfig() (*GitSyncConfig, error) {
 	workDir := getEnvOrDefault("WORK_DIR", "/tmp/git-sync-work")
 
 	config := &GitSyncConfig{
-		MinIOEndpoint:  getEnvOrDefault("MINIO_ENDPOINT", ""),
-		MinIOAccessKey: getEnvOrDefault("MINIO_ACCESS_KEY", ""),
-		MinIOSecretKey: getEnvOrDefault("MINIO_SECRET_KEY", ""),
+		StorageConfig: shared.StorageConfig{
+			MinIOEndpoint:                 getEnvOrDefault("MINIO_ENDPOINT", ""),
+			MinIOAccessKey:                getEnvOrDefault("MINIO_ACCESS_KEY", ""),
+			MinIOSecretKey:                getEnvOrDefault("MINIO_SECRET_KEY", ""),
+			MinIOUseSSL:                   getEnvOrDefault("MINIO_USE_SSL", "true") == "true",
+			MinIORegion:                   getEnvOrDefault("MINIO_REGION", ""),
+			MinIOBucketLookup:             getEnvOrDefault("MINIO_BUCKET_LOOKUP", "auto"),
+			MinIOCredentialSources:        parseCommaSeparated(getEnvOrDefault("MINIO_CREDENTIAL_SOURCES", "static")),
+			MinIOAccessKeyFile:            getEnvOrDefault("MINIO_ACCESS_KEY_FILE", ""),
+			MinIOSecretKeyFile:            getEnvOrDefault("MINIO_SECRET_KEY_FILE", ""),
+			MinIOSharedCredentialsFile:    getEnvOrDefault("MINIO_SHARED_CREDENTIALS_FILE", ""),
+			MinIOSharedCredentialsProfile: getEnvOrDefault("MINIO_SHARED_CREDENTIALS_PROFILE", ""),
+			MinIOSTSEndpoint:              getEnvOrDefault("MINIO_STS_ENDPOINT", ""),
+			MinIOWebIdentityTokenFile:     getEnvOrDefault("MINIO_WEB_IDENTITY_TOKEN_FILE", ""),
+			MinIORoleARN:                  getEnvOrDefault("MINIO_ROLE_ARN", ""),
+			MinIOCABundle:                 getEnvOrDefault("MINIO_CA_BUNDLE", ""),
+			MinIOClientCert:               getEnvOrDefault("MINIO_CLIENT_CERT", ""),
+			MinIOClientKey:                getEnvOrDefault("MINIO_CLIENT_KEY", ""),
+			MinIOSSEType:                  getEnvOrDefault("MINIO_SSE_TYPE", shared.SSETypeNone),
+			MinIOSSEKMSKeyID:              getEnvOrDefault("MINIO_SSE_KMS_KEY_ID", ""),
+			MinIOSSECKeyFile:              getEnvOrDefault("MINIO_SSE_C_KEY_FILE", ""),
+		},
 		MinIOBucket:    getEnvOrDefault("MINIO_BUCKET", "cluster-backups"),
-		MinIOUseSSL:    getEnvOrDefault("MINIO_USE_SSL", "true") == "true",
 		GitRepository:  getEnvOrDefault("GIT_REPOSITORY", ""),
 		GitBranch:      getEnvOrDefault("GIT_BRANCH", "main"),
 		GitUsername:    getEnvOrDefault("GIT_USERNAME", "cluster-backup"),
@@ -132,9 +171,20 @@ func loadGitSyncConfig() (*GitSyncConfig, error) {
 		WorkDir:        workDir,
 		RetryAttempts:  3,
 		RetryDelay:     5 * time.Second,
+		NotifyWebhookURL:      getEnvOrDefault("NOTIFY_WEBHOOK_URL", ""),
+		NotifySlackWebhookURL: getEnvOrDefault("NOTIFY_SLACK_WEBHOOK_URL", ""),
+		NotifyTeamsWebhookURL: getEnvOrDefault("NOTIFY_TEAMS_WEBHOOK_URL", ""),
+		NotifyMode:            getEnvOrDefault("NOTIFY_MODE", shared.NotifyModeAlways),
+		NotifyTemplate:        getEnvOrDefault("NOTIFY_TEMPLATE", ""),
 	}
 
-	if config.MinIOEndpoint == "" || config.MinIOAccessKey == "" || config.MinIOSecretKey == "" {
+	if config.MinIOEndpoint == "" {
+		return nil, fmt.Errorf("MinIO configuration is incomplete")
+	}
+
+	// Static keys are only mandatory when they are the sole credential source
+	if len(config.MinIOCredentialSources) == 1 && config.MinIOCredentialSources[0] == shared.CredentialSourceStatic &&
+		(config.MinIOAccessKey == "" || config.MinIOSecretKey == "") {
 		return nil, fmt.Errorf("MinIO configuration is incomplete")
 	}
 
@@ -153,6 +203,16 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+func parseCommaSeparated(input string) []string {
+	var result []string
+	for _, part := range strings.Split(input, ",") {
+		if trimmed := strings.TrimSpace(part); trimmed != "" {
+			result = append(result, trimmed)
+		}
+	}
+	return result
+}
+
 func NewGitSyncLogger() *GitSyncLogger {
 	return &GitSyncLogger{
 		logLevel: getEnvOrDefault("LOG_LEVEL", "info"),
@@ -220,14 +280,22 @@ func (gsl *GitSyncLogger) Fatal(operation, message string, data map[string]inter
 }
 
 func NewGitSync(config *GitSyncConfig, logger *GitSyncLogger) (*GitSync, error) {
-	minioClient, err := minio.New(config.MinIOEndpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(config.MinIOAccessKey, config.MinIOSecretKey, ""),
-		Secure: config.MinIOUseSSL,
-	})
+	minioClient, err := shared.NewMinIOClient(&config.StorageConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create MinIO client: %v", err)
 	}
 
+	sse, err := shared.NewServerSideEncryption(&config.StorageConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure server-side encryption: %v", err)
+	}
+
+	notifier, err := shared.NewNotifier(config.NotifyWebhookURL, config.NotifySlackWebhookURL, config.NotifyTeamsWebhookURL,
+		config.NotifyMode, config.NotifyTemplate, config.RetryAttempts, config.RetryDelay)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure notifications: %v", err)
+	}
+
 	metrics := &GitSyncMetrics{
 		SyncDuration: promauto.NewHistogram(prometheus.HistogramOpts{
 			Name: "git_sync_duration_seconds",
@@ -254,14 +322,17 @@ func NewGitSync(config *GitSyncConfig, logger *GitSyncLogger) (*GitSync, error)
 	return &GitSync{
 		config:      config,
 		minioClient: minioClient,
+		sse:         sse,
 		metrics:     metrics,
 		ctx:         context.Background(),
 		logger:      logger,
+		notifier:    notifier,
 	}, nil
 }
 
 func (gs *GitSync) Run() error {
 	startTime := time.Now()
+	gs.stats = syncStats{StartedAt: startTime, Failures: make(map[string]string)}
 	defer func() {
 		gs.metrics.SyncDuration.Observe(time.Since(startTime).Seconds())
 	}()
@@ -321,6 +392,37 @@ func (gs *GitSync) Run() error {
 	return nil
 }
 
+// notifySync sends the outcome of Run to the configured webhooks. A sync
+// counts as a change when it pushed a commit.
+func (gs *GitSync) notifySync(runErr error) {
+	notification := &shared.Notification{
+		Source:          "git-sync",
+		Status:          "success",
+		Changed:         gs.stats.Pushed,
+		StartedAt:       gs.stats.StartedAt.UTC(),
+		DurationSeconds: time.Since(gs.stats.StartedAt).Seconds(),
+		Summary: map[string]interface{}{
+			"clusters":         gs.stats.Clusters,
+			"files_downloaded": gs.stats.FilesDownloaded,
+			"pushed":           gs.stats.Pushed,
+		},
+		Failures: gs.stats.Failures,
+	}
+	if runErr != nil {
+		notification.Status = "failure"
+		notification.Error = runErr.Error()
+	} else if len(gs.stats.Failures) > 0 {
+		notification.Status = "failure"
+	}
+
+	_, errors := gs.notifier.Send(notification)
+	for _, err := range errors {
+		gs.logger.Error("notification_failed", "Failed to send notification", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
 func (gs *GitSync) setupWorkDirectory() error {
 	// Remove existing work directory and recreate (now it's a subdirectory)
 	log.Printf("Setting up work directory: %s", gs.config.WorkDir)
@@ -474,13 +576,18 @@ func (gs *GitSync) downloadAndMergeBackups() (int, error) {
 		Recursive: true,
 	})
 
-	downloadCount := 0
+	var objects []minio.ObjectInfo
 	for object := range objectCh {
 		if object.Err != nil {
 			log.Printf("Error listing object: %v", object.Err)
 			continue
 		}
+		objects = append(objects, object)
+	}
+	latestSnapshots := latestSnapshotPerCluster(objects, gs.snapshotComplete)
 
+	downloadCount := 0
+	for _, object := range objects {
 		// Parse new structure: clusterbackup/{cluster-name}/{namespace}/{resource-type}/{resource-name}.yaml
 		parts := strings.Split(object.Key, "/")
 		if len(parts) >= 2 && parts[0] == "clusterbackup" {
@@ -488,7 +595,12 @@ func (gs *GitSync) downloadAndMergeBackups() (int, error) {
 			clusters[clusterName] = true
 		}
 
-		localPath := filepath.Join(backupDir, object.Key)
+		repoKey, ok := repositoryKey(object.Key, latestSnapshots)
+		if !ok {
+			continue
+		}
+
+		localPath := filepath.Join(backupDir, repoKey)
 		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
 			log.Printf("Error creating directory for %s: %v", localPath, err)
 			continue
@@ -496,6 +608,11 @@ func (gs *GitSync) downloadAndMergeBackups() (int, error) {
 
 		if err := gs.downloadFile(object.Key, localPath); err != nil {
 			log.Printf("Error downloading %s: %v", object.Key, err)
+			if len(parts) >= 2 {
+				if _, seen := gs.stats.Failures[parts[1]]; !seen {
+					gs.stats.Failures[parts[1]] = fmt.Sprintf("download of %s failed: %v", object.Key, err)
+				}
+			}
 			continue
 		}
 
@@ -504,6 +621,8 @@ func (gs *GitSync) downloadAndMergeBackups() (int, error) {
 	}
 
 	log.Printf("Downloaded %d files from %d clusters", downloadCount, len(clusters))
+	gs.stats.Clusters = len(clusters)
+	gs.stats.FilesDownloaded = downloadCount
 
 	repoDir := filepath.Join(gs.config.WorkDir, "repository")
 	if err := gs.mergeBackupsToRepo(backupDir, repoDir); err != nil {
@@ -513,8 +632,92 @@ func (gs *GitSync) downloadAndMergeBackups() (int, error) {
 	return len(clusters), nil
 }
 
+// latestSnapshotPerCluster returns the newest complete run ID of every
+// cluster that writes snapshots (clusterbackup/{cluster}/snapshots/{run-id}/...).
+// Snapshots still being written or of failed runs are passed over. Clusters
+// without a complete snapshot map to "", so nothing of them is synced.
+func latestSnapshotPerCluster(objects []minio.ObjectInfo, complete func(cluster, runID string) bool) map[string]string {
+	runs := make(map[string]map[string]bool)
+	for _, object := range objects {
+		parts := strings.Split(object.Key, "/")
+		if len(parts) < 5 || parts[0] != "clusterbackup" || parts[2] != "snapshots" {
+			continue
+		}
+		if runs[parts[1]] == nil {
+			runs[parts[1]] = make(map[string]bool)
+		}
+		runs[parts[1]][parts[3]] = true
+	}
+
+	latest := make(map[string]string, len(runs))
+	for cluster, runIDs := range runs {
+		ordered := make([]string, 0, len(runIDs))
+		for runID := range runIDs {
+			ordered = append(ordered, runID)
+		}
+		// Run IDs are UTC timestamps, so lexical order is chronological
+		sort.Sort(sort.Reverse(sort.StringSlice(ordered)))
+		latest[cluster] = ""
+		for _, runID := range ordered {
+			if complete(cluster, runID) {
+				latest[cluster] = runID
+				break
+			}
+		}
+		if latest[cluster] == "" {
+			log.Printf("Warning: no complete snapshot of cluster %s, skipping it", cluster)
+		}
+	}
+	return latest
+}
+
+// snapshotComplete reports whether the backup recorded a run as complete in
+// clustermeta/{cluster}/runs/{run-id}.json. The record is written when the
+// run finishes; runs recorded before results were kept count as complete.
+func (gs *GitSync) snapshotComplete(cluster, runID string) bool {
+	object, err := gs.minioClient.GetObject(gs.ctx, gs.config.MinIOBucket, "clustermeta/"+cluster+"/runs/"+runID+".json", gs.getObjectOptions())
+	if err != nil {
+		return false
+	}
+	defer object.Close()
+
+	var record struct {
+		Result string `json:"result"`
+	}
+	if err := json.NewDecoder(object).Decode(&record); err != nil {
+		return false
+	}
+	return record.Result == "" || record.Result == "success"
+}
+
+// repositoryKey maps a bucket key to its path in the git repository. For
+// clusters using snapshots only the latest snapshot is synced, flattened to
+// the regular clusterbackup/{cluster}/{namespace}/... layout so git history
+// stays the record of changes between runs.
+func repositoryKey(objectKey string, latestSnapshots map[string]string) (string, bool) {
+	parts := strings.Split(objectKey, "/")
+	if len(parts) < 3 || parts[0] != "clusterbackup" {
+		return objectKey, true
+	}
+
+	latest, snapshotted := latestSnapshots[parts[1]]
+	if !snapshotted {
+		return objectKey, true
+	}
+	if parts[2] != "snapshots" || len(parts) < 5 || parts[3] != latest {
+		return "", false
+	}
+	return strings.Join(append(parts[:2:2], parts[4:]...), "/"), true
+}
+
+// getObjectOptions carries the SSE-C key needed to read objects written
+// with customer-provided encryption.
+func (gs *GitSync) getObjectOptions() minio.GetObjectOptions {
+	return minio.GetObjectOptions{ServerSideEncryption: shared.ReadEncryption(gs.sse)}
+}
+
 func (gs *GitSync) downloadFile(objectKey, localPath string) error {
-	object, err := gs.minioClient.GetObject(gs.ctx, gs.config.MinIOBucket, objectKey, minio.GetObjectOptions{})
+	object, err := gs.minioClient.GetObject(gs.ctx, gs.config.MinIOBucket, objectKey, gs.getObjectOptions())
 	if err != nil {
 		return err
 	}
@@ -627,6 +830,7 @@ Generated by KubeBackup Git-Sync Service`,
 	}
 
 	log.Println("Incremental push completed successfully")
+	gs.stats.Pushed = true
 	return nil
 }
 
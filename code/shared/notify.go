@@ -0,0 +1,248 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Notification modes
+const (
+	NotifyModeAlways          = "always"
+	NotifyModeFailure         = "failure"
+	NotifyModeFailureOrChange = "failure-or-change"
+)
+
+// Webhook payload formats
+const (
+	notifyFormatGeneric = "generic"
+	notifyFormatSlack   = "slack"
+	notifyFormatTeams   = "teams"
+)
+
+// defaultNotifyTemplate renders the message text of every notification.
+const defaultNotifyTemplate = `{{.Source}} {{.Status}}{{if .Cluster}} on cluster {{.Cluster}}{{end}}{{if .RunID}} (run {{.RunID}}){{end}} after {{printf "%.1f" .DurationSeconds}}s` +
+	`{{if .Error}}: {{.Error}}{{end}}` +
+	`{{range $name, $err := .Failures}}
+- {{$name}}: {{$err}}{{end}}`
+
+// Notification describes the outcome of a backup, cleanup or git-sync run.
+type Notification struct {
+	Source          string                 `json:"source"` // "backup", "cleanup", "git-sync"
+	Cluster         string                 `json:"cluster,omitempty"`
+	RunID           string                 `json:"run_id,omitempty"`
+	Status          string                 `json:"status"` // "success", "failure"
+	Changed         bool                   `json:"changed"`
+	StartedAt       time.Time              `json:"started_at"`
+	DurationSeconds float64                `json:"duration_seconds"`
+	Summary         map[string]interface{} `json:"summary,omitempty"`
+	Failures        map[string]string      `json:"failures,omitempty"` // e.g. namespace -> error
+	Error           string                 `json:"error,omitempty"`
+}
+
+func (n *Notification) failed() bool {
+	return n.Status != "success"
+}
+
+type notifyTarget struct {
+	format string
+	url    string
+}
+
+// Notifier posts notifications to the configured webhooks.
+type Notifier struct {
+	targets       []notifyTarget
+	mode          string
+	template      *template.Template
+	retryAttempts int
+	retryDelay    time.Duration
+	client        *http.Client
+}
+
+// NewNotifier returns nil when no webhook is configured.
+func NewNotifier(genericURL, slackURL, teamsURL, mode, templateText string, retryAttempts int, retryDelay time.Duration) (*Notifier, error) {
+	var targets []notifyTarget
+	for _, target := range []notifyTarget{
+		{notifyFormatGeneric, genericURL},
+		{notifyFormatSlack, slackURL},
+		{notifyFormatTeams, teamsURL},
+	} {
+		if target.url != "" {
+			targets = append(targets, target)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, nil
+	}
+
+	switch mode {
+	case "":
+		mode = NotifyModeAlways
+	case NotifyModeAlways, NotifyModeFailure, NotifyModeFailureOrChange:
+	default:
+		return nil, fmt.Errorf("invalid NOTIFY_MODE %q (expected always, failure or failure-or-change)", mode)
+	}
+
+	if templateText == "" {
+		templateText = defaultNotifyTemplate
+	}
+	tmpl, err := template.New("notification").Parse(templateText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NOTIFY_TEMPLATE: %v", err)
+	}
+
+	if retryAttempts < 1 {
+		retryAttempts = 1
+	}
+
+	return &Notifier{
+		targets:       targets,
+		mode:          mode,
+		template:      tmpl,
+		retryAttempts: retryAttempts,
+		retryDelay:    retryDelay,
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// shouldSend applies the notification mode.
+func (n *Notifier) shouldSend(notification *Notification) bool {
+	switch n.mode {
+	case NotifyModeFailure:
+		return notification.failed()
+	case NotifyModeFailureOrChange:
+		return notification.failed() || notification.Changed
+	default:
+		return true
+	}
+}
+
+// Send delivers a notification to every webhook, retrying failed requests.
+// It reports whether the notification passed the mode filter and returns one
+// error per webhook that could not be reached.
+func (n *Notifier) Send(notification *Notification) (bool, []error) {
+	if n == nil || !n.shouldSend(notification) {
+		return false, nil
+	}
+
+	var message bytes.Buffer
+	if err := n.template.Execute(&message, notification); err != nil {
+		return false, []error{fmt.Errorf("failed to render notification: %v", err)}
+	}
+
+	var errors []error
+	for _, target := range n.targets {
+		body, err := json.Marshal(notificationPayload(target.format, notification, message.String()))
+		if err != nil {
+			errors = append(errors, err)
+			continue
+		}
+		if err := n.post(target.url, body); err != nil {
+			errors = append(errors, fmt.Errorf("%s webhook: %v", target.format, err))
+		}
+	}
+	return true, errors
+}
+
+func (n *Notifier) post(url string, body []byte) error {
+	var lastErr error
+	for attempt := 1; attempt <= n.retryAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(n.retryDelay)
+		}
+		resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("unexpected status %s", resp.Status)
+		// Client errors other than rate limiting will not succeed on retry
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			break
+		}
+	}
+	return lastErr
+}
+
+// notificationPayload builds the request body for a webhook format.
+func notificationPayload(format string, notification *Notification, message string) interface{} {
+	title := fmt.Sprintf("%s %s", notification.Source, notification.Status)
+	if notification.Cluster != "" {
+		title += " - " + notification.Cluster
+	}
+
+	switch format {
+	case notifyFormatSlack:
+		color := "good"
+		if notification.failed() {
+			color = "danger"
+		}
+		var fields []map[string]interface{}
+		for _, fact := range notificationFacts(notification) {
+			fields = append(fields, map[string]interface{}{"title": fact[0], "value": fact[1], "short": true})
+		}
+		return map[string]interface{}{
+			"text": title,
+			"attachments": []map[string]interface{}{{
+				"color":  color,
+				"text":   message,
+				"fields": fields,
+			}},
+		}
+
+	case notifyFormatTeams:
+		color := "2EB886"
+		if notification.failed() {
+			color = "D00000"
+		}
+		var facts []map[string]string
+		for _, fact := range notificationFacts(notification) {
+			facts = append(facts, map[string]string{"name": fact[0], "value": fact[1]})
+		}
+		return map[string]interface{}{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"themeColor": color,
+			"summary":    title,
+			"title":      title,
+			"text":       strings.ReplaceAll(message, "\n", "<br>"),
+			"sections":   []map[string]interface{}{{"facts": facts}},
+		}
+
+	default:
+		return map[string]interface{}{
+			"text":         message,
+			"notification": notification,
+		}
+	}
+}
+
+// notificationFacts lists the summary of a notification as sorted
+// name/value pairs for chat formats.
+func notificationFacts(notification *Notification) [][2]string {
+	facts := [][2]string{{"duration", fmt.Sprintf("%.1fs", notification.DurationSeconds)}}
+	if notification.RunID != "" {
+		facts = append(facts, [2]string{"run", notification.RunID})
+	}
+	keys := make([]string, 0, len(notification.Summary))
+	for key := range notification.Summary {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		facts = append(facts, [2]string{key, fmt.Sprintf("%v", notification.Summary[key])})
+	}
+	if len(notification.Failures) > 0 {
+		facts = append(facts, [2]string{"failures", fmt.Sprintf("%d", len(notification.Failures))})
+	}
+	return facts
+}
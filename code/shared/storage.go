@@ -0,0 +1,336 @@
+// Package shared holds the object storage client and the webhook
+// notifications used by both the backup service and git-sync.
+package shared
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// StorageConfig holds the object storage connection, credential, TLS and
+// encryption settings. Both binaries embed it in their configuration.
+type StorageConfig struct {
+	MinIOEndpoint     string
+	MinIOAccessKey    string
+	MinIOSecretKey    string
+	MinIOUseSSL       bool
+	MinIORegion       string
+	MinIOBucketLookup string
+	// Credential chain and TLS configuration
+	MinIOCredentialSources        []string
+	MinIOAccessKeyFile            string
+	MinIOSecretKeyFile            string
+	MinIOSharedCredentialsFile    string
+	MinIOSharedCredentialsProfile string
+	MinIOSTSEndpoint              string
+	MinIOWebIdentityTokenFile     string
+	MinIORoleARN                  string
+	MinIOCABundle                 string
+	MinIOClientCert               string
+	MinIOClientKey                string
+	// Server-side encryption configuration
+	MinIOSSEType     string
+	MinIOSSEKMSKeyID string
+	MinIOSSECKeyFile string
+}
+
+// Supported entries for MINIO_CREDENTIAL_SOURCES. The sources are tried in
+// the configured order and the first one that yields credentials wins.
+const (
+	CredentialSourceStatic      = "static"       // MINIO_ACCESS_KEY / MINIO_SECRET_KEY
+	CredentialSourceFile        = "file"         // MINIO_ACCESS_KEY_FILE / MINIO_SECRET_KEY_FILE, re-read on rotation
+	CredentialSourceEnv         = "env"          // AWS_* and MINIO_ROOT_USER style environment variables
+	CredentialSourceSharedFile  = "shared-file"  // AWS shared credentials file
+	CredentialSourceIAM         = "iam"          // IRSA web identity, ECS task role or EC2 instance profile
+	CredentialSourceWebIdentity = "web-identity" // explicit STS AssumeRoleWithWebIdentity endpoint
+)
+
+// NewMinIOClient builds the object storage client from the connection,
+// TLS and credential settings in the configuration.
+func NewMinIOClient(config *StorageConfig) (*minio.Client, error) {
+	transport, err := newMinIOTransport(config)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := newMinIOCredentials(config, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	lookup, err := parseBucketLookup(config.MinIOBucketLookup)
+	if err != nil {
+		return nil, err
+	}
+
+	return minio.New(config.MinIOEndpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       config.MinIOUseSSL,
+		Transport:    transport,
+		Region:       config.MinIORegion,
+		BucketLookup: lookup,
+	})
+}
+
+func newMinIOTransport(config *StorageConfig) (*http.Transport, error) {
+	transport, err := minio.DefaultTransport(config.MinIOUseSSL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MinIO transport: %v", err)
+	}
+
+	if config.MinIOCABundle == "" && config.MinIOClientCert == "" {
+		return transport, nil
+	}
+
+	tlsConfig := transport.TLSClientConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	if config.MinIOCABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(config.MinIOCABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle %s: %v", config.MinIOCABundle, err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", config.MinIOCABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.MinIOClientCert != "" {
+		if config.MinIOClientKey == "" {
+			return nil, fmt.Errorf("MINIO_CLIENT_KEY is required when MINIO_CLIENT_CERT is set")
+		}
+		cert, err := tls.LoadX509KeyPair(config.MinIOClientCert, config.MinIOClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load MinIO client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+func newMinIOCredentials(config *StorageConfig, transport http.RoundTripper) (*credentials.Credentials, error) {
+	httpClient := &http.Client{Transport: transport}
+	var providers []credentials.Provider
+
+	for _, source := range config.MinIOCredentialSources {
+		switch source {
+		case CredentialSourceStatic:
+			if config.MinIOAccessKey == "" || config.MinIOSecretKey == "" {
+				continue
+			}
+			providers = append(providers, &credentials.Static{
+				Value: credentials.Value{
+					AccessKeyID:     config.MinIOAccessKey,
+					SecretAccessKey: config.MinIOSecretKey,
+					SignerType:      credentials.SignatureV4,
+				},
+			})
+		case CredentialSourceFile:
+			if config.MinIOAccessKeyFile == "" || config.MinIOSecretKeyFile == "" {
+				continue
+			}
+			providers = append(providers, &rotatingFileCredentials{
+				accessKeyFile: config.MinIOAccessKeyFile,
+				secretKeyFile: config.MinIOSecretKeyFile,
+			})
+		case CredentialSourceEnv:
+			providers = append(providers, &credentials.EnvAWS{}, &credentials.EnvMinio{})
+		case CredentialSourceSharedFile:
+			providers = append(providers, &credentials.FileAWSCredentials{
+				Filename: config.MinIOSharedCredentialsFile,
+				Profile:  config.MinIOSharedCredentialsProfile,
+			})
+		case CredentialSourceIAM:
+			providers = append(providers, &credentials.IAM{Client: httpClient})
+		case CredentialSourceWebIdentity:
+			if config.MinIOSTSEndpoint == "" || config.MinIOWebIdentityTokenFile == "" {
+				return nil, fmt.Errorf("web-identity credentials require MINIO_STS_ENDPOINT and MINIO_WEB_IDENTITY_TOKEN_FILE")
+			}
+			tokenFile := config.MinIOWebIdentityTokenFile
+			providers = append(providers, &credentials.STSWebIdentity{
+				Client:      httpClient,
+				STSEndpoint: config.MinIOSTSEndpoint,
+				RoleARN:     config.MinIORoleARN,
+				GetWebIDTokenExpiry: func() (*credentials.WebIdentityToken, error) {
+					token, err := os.ReadFile(tokenFile)
+					if err != nil {
+						return nil, err
+					}
+					return &credentials.WebIdentityToken{Token: strings.TrimSpace(string(token))}, nil
+				},
+			})
+		default:
+			return nil, fmt.Errorf("unknown MinIO credential source %q", source)
+		}
+	}
+
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("no usable MinIO credential source in %v", config.MinIOCredentialSources)
+	}
+
+	return credentials.NewChainCredentials(providers), nil
+}
+
+func parseBucketLookup(value string) (minio.BucketLookupType, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "auto":
+		return minio.BucketLookupAuto, nil
+	case "dns", "virtual-host":
+		return minio.BucketLookupDNS, nil
+	case "path":
+		return minio.BucketLookupPath, nil
+	default:
+		return minio.BucketLookupAuto, fmt.Errorf("unknown MINIO_BUCKET_LOOKUP %q (expected auto, dns or path)", value)
+	}
+}
+
+// rotatingFileCredentials reads the access and secret key from mounted
+// files and reports itself expired whenever either file changes, so keys
+// rotated in the backing Secret are picked up without a restart.
+type rotatingFileCredentials struct {
+	accessKeyFile string
+	secretKeyFile string
+
+	mu       sync.Mutex
+	modTimes [2]time.Time
+}
+
+func (r *rotatingFileCredentials) Retrieve() (credentials.Value, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	accessKey, accessMod, err := readCredentialFile(r.accessKeyFile)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	secretKey, secretMod, err := readCredentialFile(r.secretKeyFile)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	r.modTimes = [2]time.Time{accessMod, secretMod}
+	return credentials.Value{
+		AccessKeyID:     accessKey,
+		SecretAccessKey: secretKey,
+		SignerType:      credentials.SignatureV4,
+	}, nil
+}
+
+func (r *rotatingFileCredentials) IsExpired() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, path := range []string{r.accessKeyFile, r.secretKeyFile} {
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().Equal(r.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// RetrieveWithCredContext is Retrieve for callers passing a credential
+// context, which file credentials do not need.
+func (r *rotatingFileCredentials) RetrieveWithCredContext(_ *credentials.CredContext) (credentials.Value, error) {
+	return r.Retrieve()
+}
+
+func readCredentialFile(path string) (string, time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to stat credential file %s: %v", path, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to read credential file %s: %v", path, err)
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", time.Time{}, fmt.Errorf("credential file %s is empty", path)
+	}
+	return value, info.ModTime(), nil
+}
+
+// Supported values for MINIO_SSE_TYPE.
+const (
+	SSETypeNone = "none"
+	SSETypeS3   = "sse-s3"
+	SSETypeKMS  = "sse-kms"
+	SSETypeC    = "sse-c"
+)
+
+// NewServerSideEncryption returns the encryption applied to uploaded objects,
+// or nil when server-side encryption is disabled.
+func NewServerSideEncryption(config *StorageConfig) (encrypt.ServerSide, error) {
+	switch strings.ToLower(strings.TrimSpace(config.MinIOSSEType)) {
+	case "", SSETypeNone:
+		return nil, nil
+	case SSETypeS3:
+		return encrypt.NewSSE(), nil
+	case SSETypeKMS:
+		if config.MinIOSSEKMSKeyID == "" {
+			return nil, fmt.Errorf("MINIO_SSE_KMS_KEY_ID is required for sse-kms")
+		}
+		return encrypt.NewSSEKMS(config.MinIOSSEKMSKeyID, nil)
+	case SSETypeC:
+		if config.MinIOSSECKeyFile == "" {
+			return nil, fmt.Errorf("MINIO_SSE_C_KEY_FILE is required for sse-c")
+		}
+		if !config.MinIOUseSSL {
+			return nil, fmt.Errorf("sse-c requires MINIO_USE_SSL=true")
+		}
+		key, err := readSSECKey(config.MinIOSSECKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return encrypt.NewSSEC(key)
+	default:
+		return nil, fmt.Errorf("unknown MINIO_SSE_TYPE %q (expected none, sse-s3, sse-kms or sse-c)", config.MinIOSSEType)
+	}
+}
+
+// readSSECKey loads a 32-byte customer key, stored either raw or base64 encoded.
+func readSSECKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSE-C key %s: %v", path, err)
+	}
+	if len(data) == 32 {
+		return data, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("SSE-C key %s must be 32 raw bytes or 32 base64-encoded bytes", path)
+	}
+	return key, nil
+}
+
+// ReadEncryption returns the encryption that has to accompany GET and HEAD
+// requests. Only SSE-C needs the key on reads; SSE-S3 and SSE-KMS objects are
+// decrypted transparently and reject encryption headers on reads.
+func ReadEncryption(sse encrypt.ServerSide) encrypt.ServerSide {
+	if sse != nil && sse.Type() == encrypt.SSEC {
+		return sse
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func TestParseBucketLookup(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    minio.BucketLookupType
+		wantErr bool
+	}{
+		{"", minio.BucketLookupAuto, false},
+		{"auto", minio.BucketLookupAuto, false},
+		{" DNS ", minio.BucketLookupDNS, false},
+		{"virtual-host", minio.BucketLookupDNS, false},
+		{"path", minio.BucketLookupPath, false},
+		{"subdomain", minio.BucketLookupAuto, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseBucketLookup(tt.value)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseBucketLookup(%q) = %v, %v, want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadSSECKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0x5a}, 32)
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"raw", key, false},
+		{"base64 with newline", []byte(base64.StdEncoding.EncodeToString(key) + "\n"), false},
+		{"too short", key[:16], true},
+		{"base64 of the wrong length", []byte(base64.StdEncoding.EncodeToString(key[:16])), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "key")
+			if err := os.WriteFile(path, tt.data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readSSECKey(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("readSSECKey succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readSSECKey: %v", err)
+			}
+			if !bytes.Equal(got, key) {
+				t.Errorf("readSSECKey = %x, want %x", got, key)
+			}
+		})
+	}
+}